@@ -0,0 +1,233 @@
+package servers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/fnspath"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const acmeHTTP01PathPrefix = "/.well-known/acme-challenge/"
+
+// ACMEConfig obtains and renews certificate automatically
+// (alternative to certFile/keyFile).
+// tls-alpn-01 challenge is served on the same listener,
+// http-01 on plain http listeners with acmeHTTP01 enabled.
+type ACMEConfig struct {
+	Enable  bool     `json:"enable" yaml:"enable" bson:"enable"`
+	Domains []string `json:"domains" yaml:"domains" bson:"domains"`
+	// CacheDir keeps account key and certificates between restarts.
+	CacheDir string `json:"cacheDir" yaml:"cacheDir" bson:"cacheDir"`
+	// DirectoryURL defaults to Let's Encrypt production.
+	DirectoryURL string `json:"directoryURL" yaml:"directoryURL" bson:"directoryURL"`
+	Email        string `json:"email" yaml:"email" bson:"email"`
+	// DirectoryCACertFile is trusted for directory TLS
+	// (e.g. Pebble test server), system roots are used if empty.
+	DirectoryCACertFile string `json:"directoryCACertFile" yaml:"directoryCACertFile" bson:"directoryCACertFile"`
+}
+
+func (c *ACMEConfig) defaultize() {
+	if c.DirectoryURL == "" {
+		c.DirectoryURL = acme.LetsEncryptURL
+	}
+}
+
+func (c *ACMEConfig) interpolate(interpolateFn func(string) string) {
+	interpolateStrings(interpolateFn, c.Domains)
+	c.CacheDir = interpolateFn(c.CacheDir)
+	c.DirectoryURL = interpolateFn(c.DirectoryURL)
+	c.Email = interpolateFn(c.Email)
+	c.DirectoryCACertFile = interpolateFn(c.DirectoryCACertFile)
+}
+
+func (c *ACMEConfig) validate() error {
+	if !c.Enable {
+		return nil
+	}
+
+	if len(c.Domains) == 0 {
+		return ErrACMENoDomains
+	}
+
+	if c.CacheDir == "" {
+		return ErrACMENoCacheDir
+	}
+
+	if v := c.DirectoryCACertFile; v != "" {
+		if exists, err := fnspath.IsExists(v); err != nil {
+			return fmt.Errorf("acme directoryCACertFile existence check failed: %w", err)
+		} else if !exists {
+			return fmt.Errorf("error (:path %q): %w", v, ErrACMEDirectoryCACertFileNotExists)
+		}
+	}
+
+	return nil
+}
+
+func (c *ACMEConfig) newManager() (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: c.DirectoryURL}
+
+	if c.DirectoryCACertFile != "" {
+		pool, err := loadCACertPool(c.DirectoryCACertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.CacheDir),
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Email:      c.Email,
+		Client:     client,
+	}, nil
+}
+
+func (c *ACMEConfig) hasDomain(host string) bool {
+	for _, v := range c.Domains {
+		if strings.EqualFold(v, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *ACMEConfig) dump(ctx *dumpctx.Ctx, w io.Writer) {
+	fmt.Fprintf(w, "%senable: %t\n", ctx.Indent(), c.Enable)
+
+	if !c.Enable {
+		return
+	}
+
+	fmt.Fprintf(w, "%sdomains: [%s]\n", ctx.Indent(), strings.Join(c.Domains, ", "))
+	fmt.Fprintf(w, "%scacheDir: %s\n", ctx.Indent(), c.CacheDir)
+	fmt.Fprintf(w, "%sdirectoryURL: %s\n", ctx.Indent(), c.DirectoryURL)
+	fmt.Fprintf(w, "%semail: %s\n", ctx.Indent(), c.Email)
+
+	if c.DirectoryCACertFile != "" {
+		fmt.Fprintf(w, "%sdirectoryCACertFile: %s\n", ctx.Indent(), c.DirectoryCACertFile)
+	}
+}
+
+// acmeManager returns (creating once) ACME manager of listener,
+// the same for its TLS config and http-01 responder.
+func (s *ServerINET) acmeManager() (*autocert.Manager, error) {
+	rt := s.runtime()
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return s.acmeManagerLocked(rt)
+}
+
+// acmeManagerLocked is acmeManager, must be called with rt.mu held.
+func (s *ServerINET) acmeManagerLocked(rt *serverINETRuntime) (*autocert.Manager, error) {
+	if rt.acme == nil {
+		m, err := s.TLS.ACME.newManager()
+		if err != nil {
+			return nil, err
+		}
+
+		rt.acme = m
+	}
+
+	return rt.acme, nil
+}
+
+// applyACME serves certificates and tls-alpn-01 challenges of manager.
+func applyACME(tlsConfig *tls.Config, m *autocert.Manager) {
+	tlsConfig.GetCertificate = m.GetCertificate
+	tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+}
+
+// acmeHTTP01 is http-01 challenge responder of ACME listener.
+type acmeHTTP01 struct {
+	config  *ACMEConfig
+	handler http.Handler
+}
+
+// acmeHTTP01s creates http-01 responders of ACME listeners
+// if there are plain http listeners with acmeHTTP01 to serve them.
+// Called before serving: http-01 challenge type of manager
+// is enabled once its handler is created.
+func (it iterator) acmeHTTP01s() (hs []acmeHTTP01, err error) {
+	unwrap := func(s Server) *ServerINET {
+		if l, ok := s.(*ServerListener); ok {
+			s = l.Server
+		}
+
+		inet, _ := s.(*ServerINET)
+
+		return inet
+	}
+
+	if it.Filter(func(s Server) bool {
+		inet := unwrap(s)
+		return inet != nil && inet.ACMEHTTP01
+	}).Len() == 0 {
+		return nil, nil
+	}
+
+	it(func(s Server) bool {
+		inet := unwrap(s)
+		if inet == nil || !inet.TLS.Enable || !inet.TLS.ACME.Enable {
+			return true
+		}
+
+		m, e := inet.acmeManager()
+		if e != nil {
+			err = newListenerError(inet, PhaseTLSLoad, e)
+			return false
+		}
+
+		// only challenge requests are routed here, fallback is never used
+		hs = append(hs, acmeHTTP01{config: &inet.TLS.ACME, handler: m.HTTPHandler(nil)})
+
+		return true
+	})
+
+	return hs, err
+}
+
+// withACMEHTTP01 responds http-01 challenges by manager
+// whose domains include requested host.
+func withACMEHTTP01(hs []acmeHTTP01, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, acmeHTTP01PathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		for _, h := range hs {
+			if h.config.hasDomain(host) {
+				// host policy of manager doesn't expect port
+				r2 := r.Clone(r.Context())
+				r2.Host = host
+
+				h.handler.ServeHTTP(w, r2)
+
+				return
+			}
+		}
+
+		http.NotFound(w, r)
+	})
+}
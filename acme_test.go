@@ -0,0 +1,261 @@
+package servers_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+)
+
+// ACME tests run against Pebble (https://github.com/letsencrypt/pebble, tested with v2.6.0):
+//
+//	pebble -config test/config/pebble-config.json
+//
+//	PEBBLE_DIRECTORY_URL=https://localhost:14000/dir \
+//	PEBBLE_CA_CERT_FILE=test/certs/pebble.minica.pem \
+//	go test -run ACME
+//
+// PEBBLE_TLS_PORT and PEBBLE_HTTP_PORT are tlsPort and httpPort
+// of Pebble config (5001 and 5002 by default),
+// PEBBLE_DOMAIN (acme.localhost by default) must resolve to 127.0.0.1 for Pebble.
+type pebbleEnv struct {
+	directoryURL string
+	caCertFile   string
+	domain       string
+	tlsPort      int
+	httpPort     int
+}
+
+func newPebbleEnv(t *testing.T) *pebbleEnv {
+	t.Helper()
+
+	e := &pebbleEnv{
+		directoryURL: os.Getenv("PEBBLE_DIRECTORY_URL"),
+		caCertFile:   os.Getenv("PEBBLE_CA_CERT_FILE"),
+		domain:       os.Getenv("PEBBLE_DOMAIN"),
+		tlsPort:      5001,
+		httpPort:     5002,
+	}
+
+	if e.directoryURL == "" {
+		t.Skip("PEBBLE_DIRECTORY_URL is not set")
+	}
+
+	if e.domain == "" {
+		e.domain = "acme.localhost"
+	}
+
+	for env, v := range map[string]*int{"PEBBLE_TLS_PORT": &e.tlsPort, "PEBBLE_HTTP_PORT": &e.httpPort} {
+		if raw := os.Getenv(env); raw != "" {
+			port, err := strconv.Atoi(raw)
+			if err != nil {
+				t.Fatalf("%s: %s", env, err)
+			}
+
+			*v = port
+		}
+	}
+
+	return e
+}
+
+// acmeYAML is http and tls blocks of ACME listener.
+// Certificate is obtained within handshake, so handshake timeout is raised.
+func (e *pebbleEnv) acmeYAML(t *testing.T) string {
+	return fmt.Sprintf(`  http:
+    readHeaderTimeout: 90s
+  tls:
+    enable: true
+    acme:
+      enable: true
+      domains: [%s]
+      cacheDir: %q
+      directoryURL: %q
+      directoryCACertFile: %q`, e.domain, t.TempDir(), e.directoryURL, e.caCertFile)
+}
+
+// obtainedCert dials addr until certificate for domain is served.
+func obtainedCert(t *testing.T, addr, domain string) {
+	t.Helper()
+
+	dialer := &net.Dialer{Timeout: 90 * time.Second}
+
+	// issued by Pebble random root, trust is not checked
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName:         domain,
+		InsecureSkipVerify: true, //nolint: gosec
+	})
+	if err != nil {
+		t.Fatalf("tls dial: %s", err)
+	}
+	defer conn.Close()
+
+	leaf := conn.ConnectionState().PeerCertificates[0]
+
+	if err := leaf.VerifyHostname(domain); err != nil {
+		t.Errorf("served certificate: %s", err)
+	}
+}
+
+// serveACME serves config, returns address of first server.
+func serveACME(t *testing.T, raw string) string {
+	t.Helper()
+
+	ss := newTestServers(t, raw)
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() { done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx)) }()
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Errorf("serve: %s", err)
+		}
+	})
+
+	return addr
+}
+
+func TestACMETLSALPN01(t *testing.T) {
+	e := newPebbleEnv(t)
+
+	addr := serveACME(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  port: %d
+%s`, e.tlsPort, e.acmeYAML(t)))
+
+	obtainedCert(t, addr, e.domain)
+}
+
+func TestACMEHTTP01(t *testing.T) {
+	e := newPebbleEnv(t)
+
+	// tls-alpn-01 fails (tlsPort is not listened), http-01 is used
+	addr := serveACME(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  port: 0
+%s
+- kind: [inet, http]
+  host: 127.0.0.1
+  port: %d
+  acmeHTTP01: true`, e.acmeYAML(t), e.httpPort))
+
+	obtainedCert(t, addr, e.domain)
+}
+
+func TestACMEValidate(t *testing.T) {
+	for _, tc := range []struct {
+		raw string
+		err error
+	}{
+		{`- kind: [inet, http]
+  tls:
+    enable: true
+    acme:
+      enable: true
+      cacheDir: /tmp`, servers.ErrACMENoDomains},
+		{`- kind: [inet, http]
+  tls:
+    enable: true
+    acme:
+      enable: true
+      domains: [example.com]`, servers.ErrACMENoCacheDir},
+		{`- kind: [inet, http]
+  tls:
+    enable: true
+    certFile: /etc/tls.cert
+    acme:
+      enable: true
+      domains: [example.com]
+      cacheDir: /tmp`, servers.ErrACMEWithCertFile},
+		{`- kind: [inet, http]
+  tls:
+    acme:
+      enable: true
+      domains: [example.com]
+      cacheDir: /tmp`, servers.ErrACMETLSDisabled},
+		{`- kind: [inet, grpc]
+  acmeHTTP01: true`, servers.ErrACMEHTTP01NotPlainHTTP},
+	} {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(tc.raw, &ss); err != nil {
+			t.Fatal(err)
+		}
+
+		ss.Defaultize("127.0.0.1", 0, "")
+
+		if err := ss.Validate(); err != tc.err {
+			t.Errorf("expected %v, got %v for %s", tc.err, err, tc.raw)
+		}
+	}
+}
+
+// TestACMEServeCalls needs no Pebble: manager is created on serve,
+// directory is reached on handshake only.
+func TestACMEServeCalls(t *testing.T) {
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    acme:
+      enable: true
+      domains: [acme.localhost]
+      cacheDir: %q
+      directoryURL: https://127.0.0.1:1/dir
+- kind: [inet, http]
+  host: 127.0.0.1
+  acmeHTTP01: true`, t.TempDir()))
+
+	// serve calls create manager concurrently, the same one must be used
+	var (
+		addrs []string
+		serve []func()
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 4)
+
+	for i := 0; i < cap(done); i++ {
+		listeners := listenTestServers(t, ss)
+		addrs = append(addrs, listenerAddr(t, listeners, ss[1].Server))
+		serve = append(serve, func() { done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx)) })
+	}
+
+	for _, fn := range serve {
+		go fn()
+	}
+
+	defer func() {
+		cancel()
+
+		for range addrs {
+			if err := <-done; err != nil {
+				t.Errorf("serve: %s", err)
+			}
+		}
+	}()
+
+	client := http.Client{Timeout: 5 * time.Second}
+
+	for _, addr := range addrs {
+		resp, err := client.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatalf("GET: %s", err)
+		}
+
+		resp.Body.Close()
+	}
+}
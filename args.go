@@ -2,6 +2,7 @@ package servers
 
 import (
 	"context"
+	"os/exec"
 	"time"
 
 	"github.com/go-x-pkg/log"
@@ -15,6 +16,21 @@ type args struct {
 	fnLogHTTPError log.FnT
 
 	ctx context.Context
+
+	pprofUNIXOnly bool
+
+	socketActivation bool
+
+	fnUpgradeCommand    func() *exec.Cmd
+	upgradeReadyTimeout time.Duration
+
+	health              *Health
+	healthShutdownDelay time.Duration
+
+	metrics *Metrics
+
+	// http-01 responders of ACME listeners being served
+	acmeHTTP01s []acmeHTTP01
 }
 
 func (cfg *args) defaultize() {
@@ -22,6 +38,8 @@ func (cfg *args) defaultize() {
 	cfg.fnLog = defaultFnLog
 	cfg.fnLogHTTPError = defaultFnLog
 	cfg.ctx = context.TODO()
+	cfg.fnUpgradeCommand = defaultFnUpgradeCommand
+	cfg.upgradeReadyTimeout = defaultUpgradeReadyTimeout
 }
 
 type Arg func(*args)
@@ -41,3 +59,46 @@ func FnLogHTTPError(v log.FnT) Arg {
 func Context(v context.Context) Arg {
 	return func(cfg *args) { cfg.ctx = v }
 }
+
+// PprofUNIXOnly restricts pprof handlers to UNIX listeners.
+// pprof enabled on INET listeners is ignored with warning.
+func PprofUNIXOnly(v bool) Arg {
+	return func(cfg *args) { cfg.pprofUNIXOnly = v }
+}
+
+// SocketActivation makes Listen take pre-opened sockets
+// from LISTEN_FDS/LISTEN_FDNAMES (systemd socket activation).
+// Sockets are matched by fdName or by address,
+// servers with no matched socket are listened as usual.
+func SocketActivation(v bool) Arg {
+	return func(cfg *args) { cfg.socketActivation = v }
+}
+
+// FnUpgradeCommand builds command Upgrader starts.
+// Defaults to current executable with same arguments.
+func FnUpgradeCommand(v func() *exec.Cmd) Arg {
+	return func(cfg *args) { cfg.fnUpgradeCommand = v }
+}
+
+// UpgradeReadyTimeout is how long Upgrader waits for new process readiness.
+func UpgradeReadyTimeout(v time.Duration) Arg {
+	return func(cfg *args) { cfg.upgradeReadyTimeout = v }
+}
+
+// WithHealth sets Health served by listeners with health enabled.
+// If not set, new Health (serving) is created per Serve* call.
+func WithHealth(v *Health) Arg {
+	return func(cfg *args) { cfg.health = v }
+}
+
+// HealthShutdownDelay is time between readiness goes not serving
+// and servers shutdown, for load balancers to drain.
+func HealthShutdownDelay(v time.Duration) Arg {
+	return func(cfg *args) { cfg.healthShutdownDelay = v }
+}
+
+// WithMetrics enables connection, TLS handshake, HTTP and gRPC metrics.
+// Metrics are exposed on listeners with metrics enabled in config.
+func WithMetrics(v *Metrics) Arg {
+	return func(cfg *args) { cfg.metrics = v }
+}
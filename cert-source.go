@@ -0,0 +1,348 @@
+package servers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-x-pkg/log"
+)
+
+// CertSource serves server certificates (and client CA pool, CRLs)
+// through tls.Config.GetCertificate and tls.Config.GetConfigForClient,
+// so files can be reloaded without restarting listeners.
+//
+// Failed reload keeps previously loaded certificates.
+type CertSource struct {
+	certs      []TLSCertificateConfig
+	caCertFile string
+	crlFiles   []string
+
+	expiryWarning time.Duration
+
+	fnLog log.FnT
+
+	mu       sync.RWMutex
+	loaded   []*tls.Certificate
+	caPool   *x509.CertPool
+	crls     []*pkix.CertificateList
+	staples  []ocspStaple
+	stamps   []fileStamp
+	loadedAt time.Time
+
+	// reloaded is signaled on successful reload
+	reloaded chan struct{}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(paths ...string) []fileStamp {
+	stamps := make([]fileStamp, len(paths))
+
+	for i, path := range paths {
+		if path == "" {
+			continue
+		}
+
+		if fi, err := os.Stat(path); err == nil {
+			stamps[i] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+		}
+	}
+
+	return stamps
+}
+
+func newCertSource(certs []TLSCertificateConfig, caCertFile string, crlFiles []string,
+	expiryWarning time.Duration, fnLog log.FnT,
+) (*CertSource, error) {
+	cs := &CertSource{
+		certs:         certs,
+		caCertFile:    caCertFile,
+		crlFiles:      crlFiles,
+		expiryWarning: expiryWarning,
+		fnLog:         fnLog,
+		reloaded:      make(chan struct{}, 1),
+	}
+
+	if err := cs.Reload(); err != nil {
+		return nil, err
+	}
+
+	cs.warnExpiry()
+
+	return cs, nil
+}
+
+func (cs *CertSource) files() []string {
+	files := make([]string, 0, 3*len(cs.certs)+1+len(cs.crlFiles))
+
+	for _, c := range cs.certs {
+		files = append(files, c.CertFile, c.KeyFile, c.OCSPStapleFile)
+	}
+
+	files = append(files, cs.caCertFile)
+
+	return append(files, cs.crlFiles...)
+}
+
+// Reload reads certificates, keys, CA and CRL files.
+// On error previously loaded ones are kept.
+func (cs *CertSource) Reload() error {
+	stamps := statFiles(cs.files()...)
+
+	var (
+		loaded = make([]*tls.Certificate, 0, len(cs.certs))
+		caPool *x509.CertPool
+		crls   = make([]*pkix.CertificateList, 0, len(cs.crlFiles))
+	)
+
+	for _, c := range cs.certs {
+		cert, err := loadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return err
+		}
+
+		loaded = append(loaded, cert)
+	}
+
+	if cs.caCertFile != "" {
+		pool, err := loadCACertPool(cs.caCertFile)
+		if err != nil {
+			return err
+		}
+
+		caPool = pool
+	}
+
+	for _, path := range cs.crlFiles {
+		crl, err := loadCRL(path)
+		if err != nil {
+			return err
+		}
+
+		crls = append(crls, crl)
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.staples = cs.carryStaples(loaded)
+	cs.loaded = loaded
+	cs.caPool = caPool
+	cs.crls = crls
+	cs.stamps = stamps
+	cs.loadedAt = time.Now()
+
+	select {
+	case cs.reloaded <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (cs *CertSource) isChanged() bool {
+	stamps := statFiles(cs.files()...)
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	for i := range stamps {
+		if !stamps[i].modTime.Equal(cs.stamps[i].modTime) || stamps[i].size != cs.stamps[i].size {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (cs *CertSource) reload(reason string) {
+	if err := cs.Reload(); err != nil {
+		cs.fnLog(log.Error, "tls reload (:reason %s) failed, keep serving previous certificate: %s", reason, err)
+		return
+	}
+
+	cs.fnLog(log.Info, "tls reload (:reason %s :certs %d) OK, not after %s",
+		reason, len(cs.certs), cs.NotAfter().Format(time.RFC3339))
+
+	cs.warnExpiry()
+}
+
+// warnExpiry logs certificates expiring within expiryWarning.
+func (cs *CertSource) warnExpiry() {
+	if cs.expiryWarning <= 0 {
+		return
+	}
+
+	for i, cert := range cs.Certificates() {
+		if cert.Leaf == nil {
+			continue
+		}
+
+		if left := time.Until(cert.Leaf.NotAfter); left < cs.expiryWarning {
+			cs.fnLog(log.Warn, "tls certificate (:cert %q :subject %q) expires in %s, not after %s",
+				cs.certs[i].CertFile, cert.Leaf.Subject, left.Truncate(time.Second),
+				cert.Leaf.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+// watch reloads on files change (polled each interval) and on SIGHUP
+// until ctx is done.
+func (cs *CertSource) watch(ctx context.Context, interval time.Duration, sighup bool) {
+	var (
+		sig  chan os.Signal
+		tick <-chan time.Time
+	)
+
+	if sighup {
+		sig = make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+
+		defer signal.Stop(sig)
+	}
+
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			cs.reload("sighup")
+		case <-tick:
+			if cs.isChanged() {
+				cs.reload("files changed")
+			}
+		}
+	}
+}
+
+// Certificate returns currently served default certificate.
+func (cs *CertSource) Certificate() *tls.Certificate {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	for i, cert := range cs.loaded {
+		if cs.certs[i].Default {
+			return cert
+		}
+	}
+
+	if len(cs.loaded) != 0 {
+		return cs.loaded[0]
+	}
+
+	return nil
+}
+
+// Certificates returns currently served certificates
+// in order of configuration.
+func (cs *CertSource) Certificates() []*tls.Certificate {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	return append([]*tls.Certificate(nil), cs.loaded...)
+}
+
+// NotAfter returns earliest expiry of currently served certificates.
+func (cs *CertSource) NotAfter() time.Time {
+	var notAfter time.Time
+
+	for _, cert := range cs.Certificates() {
+		if cert.Leaf != nil && (notAfter.IsZero() || cert.Leaf.NotAfter.Before(notAfter)) {
+			notAfter = cert.Leaf.NotAfter
+		}
+	}
+
+	return notAfter
+}
+
+// LoadedAt returns time of last successful (re)load.
+func (cs *CertSource) LoadedAt() time.Time {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	return cs.loadedAt
+}
+
+func (cs *CertSource) clientCAs() *x509.CertPool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	return cs.caPool
+}
+
+func (cs *CertSource) clientCRLs() []*pkix.CertificateList {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	return cs.crls
+}
+
+// GetCertificate selects certificate by client SNI.
+func (cs *CertSource) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	if cert := selectCertificate(hello, cs.certs, cs.loaded); cert != nil {
+		return cert, nil
+	}
+
+	return nil, ErrTLSNoCertificate
+}
+
+// apply binds tls.Config to source.
+// Client CA pool can't be swapped in place, so config
+// is cloned per handshake with actual pool.
+func (cs *CertSource) apply(tlsConfig *tls.Config) {
+	if len(cs.certs) != 0 {
+		tlsConfig.GetCertificate = cs.GetCertificate
+	}
+
+	if cs.caCertFile == "" {
+		return
+	}
+
+	tlsConfig.ClientCAs = cs.clientCAs()
+
+	// clone lazily: tlsConfig may be altered by caller after apply (e.g. ALPN)
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := tlsConfig.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = cs.clientCAs()
+
+		return c, nil
+	}
+}
+
+func loadX509KeyPair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error load x509 key pair (:cert %q :key %q): %w",
+			certFile, keyFile, err)
+	}
+
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("error parse x509 certificate (:cert %q): %w", certFile, err)
+		}
+	}
+
+	return &cert, nil
+}
@@ -0,0 +1,222 @@
+package servers_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/servers"
+)
+
+func TestCertSourceReload(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	first := newTestCert(t, dir, "server", ca, testCertOpts{
+		cn: "first", ips: []net.IP{net.IPv4(127, 0, 0, 1)}, notAfter: time.Now().Add(time.Hour),
+	})
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    reload:
+      enable: true
+      interval: 20ms
+      sighup: false`, first.certFile, first.keyFile))
+
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(func(servers.Server) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		}, servers.Context(ctx), servers.FnLog((&testLog{}).fn))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	servedCN := func() string {
+		t.Helper()
+
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13})
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	if cn := servedCN(); cn != "first" {
+		t.Fatalf("unexpected served cert %q", cn)
+	}
+
+	inet := ss[0].Server.(*servers.ServerINET)
+
+	if got := inet.CertSource().NotAfter(); !got.Equal(first.cert.NotAfter) {
+		t.Errorf("not after: got %s, expected %s", got, first.cert.NotAfter)
+	}
+
+	// rotate files in place, poller must pick them up
+	second := newTestCert(t, dir, "server", ca, testCertOpts{
+		cn: "second", ips: []net.IP{net.IPv4(127, 0, 0, 1)}, notAfter: time.Now().Add(48 * time.Hour),
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedCN() != "second" {
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated certificate is not served")
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if got := inet.CertSource().NotAfter(); !got.Equal(second.cert.NotAfter) {
+		t.Errorf("not after: got %s, expected %s", got, second.cert.NotAfter)
+	}
+
+	// broken files must not replace served certificate
+	if err := os.WriteFile(second.certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := inet.CertSource().Reload(); err == nil {
+		t.Errorf("expected reload of broken certificate to fail")
+	}
+
+	if cn := servedCN(); cn != "second" {
+		t.Errorf("previous certificate must be kept, got %q", cn)
+	}
+}
+
+func TestCertSourceSharedByServeCalls(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    reload:
+      enable: true`, srv.certFile, srv.keyFile))
+
+	// keep test process alive on SIGHUP whatever watchers are running
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+
+	defer signal.Stop(sig)
+
+	// config is plain value, copy of not served one has no runtime state
+	cp := *ss[0].Server.(*servers.ServerINET)
+
+	lg := testLog{}
+
+	serve := func() (string, func()) {
+		listeners := listenTestServers(t, ss)
+		addr := listenerAddr(t, listeners, ss[0].Server)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+
+		go func() {
+			done <- listeners.ServeHTTP(func(servers.Server) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			}, servers.Context(ctx), servers.FnLog(lg.fn))
+		}()
+
+		return addr, func() { cancel(); <-done }
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	dial := func(addr string) {
+		t.Helper()
+
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13})
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+
+		conn.Close()
+	}
+
+	sighup := func(expected int) {
+		t.Helper()
+
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for lg.count("tls reload (:reason sighup") < expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("sighup reload is not logged")
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+
+		if n := lg.count("tls reload (:reason sighup"); n != expected {
+			t.Errorf("expected %d reloads, got %d", expected, n)
+		}
+	}
+
+	first, stopFirst := serve()
+	second, stopSecond := serve()
+
+	defer stopSecond()
+
+	// cert source is read while served
+	dumped := make(chan struct{})
+
+	go func() {
+		defer close(dumped)
+
+		for i := 0; i < 10; i++ {
+			dctx := dumpctx.Ctx{}
+			dctx.Init()
+
+			ss.Dump(&dctx, io.Discard)
+		}
+	}()
+
+	dial(first)
+	dial(second)
+	<-dumped
+
+	if cp.CertSource() != nil {
+		t.Errorf("copy of config shares cert source")
+	}
+
+	// one watcher for both serve calls
+	sighup(1)
+
+	// watcher outlives serve call started it
+	stopFirst()
+	sighup(2)
+	dial(second)
+}
@@ -38,15 +38,63 @@ type ClientAuthTLSConfig struct {
 	AuthType clientAuthTypeTLS `json:"authType" yaml:"authType" bson:"authType"`
 	// CARoot certificate for clients certificates. Optional.
 	CACertFile string `json:"caCertFile" yaml:"caCertFile" bson:"caCertFile"`
+	// Allow-lists are OR-ed: client is accepted if any entry of any list matches.
+	// Client without certificate is rejected if any allow-list is set.
+	//
 	// If set, server will verifie Common Name of certificate given by client has in this list.
 	// Otherwise server return Unauthtorized response.
 	ClientCommonNames []string `json:"clientCommonNames" yaml:"clientCommonNames" bson:"clientCommonNames"`
+	// If set, server will verify one of SAN DNS names of client certificate is in this list.
+	ClientDNSNames []string `json:"clientDNSNames" yaml:"clientDNSNames" bson:"clientDNSNames"`
+	// If set, server will verify one of SAN URIs of client certificate is in this list.
+	ClientURIs []string `json:"clientURIs" yaml:"clientURIs" bson:"clientURIs"`
+	// If set, server will verify SPIFFE ID (spiffe:// SAN URI) of client certificate is in this list.
+	ClientSPIFFEIDs []string `json:"clientSPIFFEIDs" yaml:"clientSPIFFEIDs" bson:"clientSPIFFEIDs"`
+
+	// CRLFiles (PEM or DER) are checked for client certificate serial,
+	// reloaded along with caCertFile. Requires verified client certificates.
+	CRLFiles []string `json:"crlFiles" yaml:"crlFiles" bson:"crlFiles"`
+	// OCSP is checked in addition to CRLs, client is rejected if any
+	// of them reports certificate revoked.
+	OCSP ClientAuthOCSPConfig `json:"ocsp" yaml:"ocsp" bson:"ocsp"`
+	// RevocationPolicy is "hard-fail" (client is rejected if neither CRL
+	// nor OCSP knows certificate status) or "soft-fail" (accepted with warning).
+	RevocationPolicy string `json:"revocationPolicy" yaml:"revocationPolicy" bson:"revocationPolicy"`
 }
 
 func (c *ClientAuthTLSConfig) defaultize() {
 	if c.AuthType == clientAuthTypeTLSUnknown {
 		c.AuthType = defaultClientAuthTypeTLS
 	}
+
+	if c.RevocationPolicy == "" {
+		c.RevocationPolicy = RevocationPolicyHardFail
+	}
+
+	c.OCSP.defaultize()
+}
+
+func (c *ClientAuthTLSConfig) interpolate(interpolateFn func(string) string) {
+	c.CACertFile = interpolateFn(c.CACertFile)
+	interpolateStrings(interpolateFn, c.ClientCommonNames)
+	interpolateStrings(interpolateFn, c.ClientDNSNames)
+	interpolateStrings(interpolateFn, c.ClientURIs)
+	interpolateStrings(interpolateFn, c.ClientSPIFFEIDs)
+	interpolateStrings(interpolateFn, c.CRLFiles)
+	c.OCSP.interpolate(interpolateFn)
+	c.RevocationPolicy = interpolateFn(c.RevocationPolicy)
+}
+
+func (c *ClientAuthTLSConfig) validate() error {
+	if !c.Enable {
+		return nil
+	}
+
+	if err := c.validateAllowList(); err != nil {
+		return err
+	}
+
+	return c.validateRevocation()
 }
 
 func (c *ClientAuthTLSConfig) dump(ctx *dumpctx.Ctx, w io.Writer) {
@@ -56,5 +104,14 @@ func (c *ClientAuthTLSConfig) dump(ctx *dumpctx.Ctx, w io.Writer) {
 		fmt.Fprintf(w, "%sauthType: %s\n", ctx.Indent(), c.AuthType.orDefault())
 		fmt.Fprintf(w, "%scaCertFile: %q\n", ctx.Indent(), c.CACertFile)
 		fmt.Fprintf(w, "%sclientCommonNames: %s\n", ctx.Indent(), c.ClientCommonNames)
+		fmt.Fprintf(w, "%sclientDNSNames: %s\n", ctx.Indent(), c.ClientDNSNames)
+		fmt.Fprintf(w, "%sclientURIs: %s\n", ctx.Indent(), c.ClientURIs)
+		fmt.Fprintf(w, "%sclientSPIFFEIDs: %s\n", ctx.Indent(), c.ClientSPIFFEIDs)
+		fmt.Fprintf(w, "%scrlFiles: %s\n", ctx.Indent(), c.CRLFiles)
+		fmt.Fprintf(w, "%socsp:\n", ctx.Indent())
+		ctx.Wrap(func() {
+			c.OCSP.dump(ctx, w)
+		})
+		fmt.Fprintf(w, "%srevocationPolicy: %s\n", ctx.Indent(), c.RevocationPolicy)
 	})
 }
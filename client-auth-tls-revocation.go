@@ -0,0 +1,462 @@
+package servers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/fnspath"
+	"github.com/go-x-pkg/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// RevocationPolicyHardFail rejects client when revocation status
+	// can't be determined.
+	RevocationPolicyHardFail = "hard-fail"
+	// RevocationPolicySoftFail accepts client (and logs warning) when
+	// revocation status can't be determined. Revoked client is rejected anyway.
+	RevocationPolicySoftFail = "soft-fail"
+
+	RevocationSourceCRL  = "crl"
+	RevocationSourceOCSP = "ocsp"
+)
+
+// ClientAuthOCSPConfig checks client certificates with OCSP responder of issuer.
+type ClientAuthOCSPConfig struct {
+	Enable bool `json:"enable" yaml:"enable" bson:"enable"`
+	// ResponderURL overrides OCSP server of client certificate (AIA extension).
+	ResponderURL string `json:"responderURL" yaml:"responderURL" bson:"responderURL"`
+	// Timeout of responder request.
+	Timeout time.Duration `json:"timeout" yaml:"timeout" bson:"timeout"`
+	// CacheTTL caps caching of responses, they are cached
+	// until nextUpdate (or for CacheTTL if response has none).
+	CacheTTL time.Duration `json:"cacheTTL" yaml:"cacheTTL" bson:"cacheTTL"`
+}
+
+func (c *ClientAuthOCSPConfig) defaultize() {
+	if c.Timeout == 0 {
+		c.Timeout = defaultClientAuthOCSPTimeout
+	}
+
+	if c.CacheTTL == 0 {
+		c.CacheTTL = defaultClientAuthOCSPCacheTTL
+	}
+}
+
+func (c *ClientAuthOCSPConfig) interpolate(interpolateFn func(string) string) {
+	c.ResponderURL = interpolateFn(c.ResponderURL)
+}
+
+func (c *ClientAuthOCSPConfig) validate() error {
+	if !c.Enable {
+		return nil
+	}
+
+	if c.Timeout < 0 || c.CacheTTL < 0 {
+		return fmt.Errorf("(:timeout %s :cacheTTL %s): %w", c.Timeout, c.CacheTTL, ErrOCSPNegativeValue)
+	}
+
+	if v := c.ResponderURL; v != "" {
+		if u, err := url.Parse(v); err != nil {
+			return fmt.Errorf("ocsp responder url %q: %w", v, err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("(:responderURL %q): %w", v, ErrOCSPResponderURLInvalid)
+		}
+	}
+
+	return nil
+}
+
+func (c *ClientAuthOCSPConfig) dump(ctx *dumpctx.Ctx, w io.Writer) {
+	fmt.Fprintf(w, "%senable: %t\n", ctx.Indent(), c.Enable)
+
+	if !c.Enable {
+		return
+	}
+
+	fmt.Fprintf(w, "%sresponderURL: %q\n", ctx.Indent(), c.ResponderURL)
+	fmt.Fprintf(w, "%stimeout: %s\n", ctx.Indent(), c.Timeout)
+	fmt.Fprintf(w, "%scacheTTL: %s\n", ctx.Indent(), c.CacheTTL)
+}
+
+// RevocationError is returned from TLS handshake (wrapped in ClientCertError)
+// when client certificate is revoked (ErrClientCertRevoked) or
+// its status can't be determined under hard-fail policy (ErrRevocationStatusUnknown).
+type RevocationError struct {
+	// Serial of client certificate, hex.
+	Serial string
+	Issuer string
+	// Source is crl or ocsp for revoked certificate.
+	Source    string
+	RevokedAt time.Time
+
+	Err error
+}
+
+func (e *RevocationError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("(:serial %s :issuer %q): %s", e.Serial, e.Issuer, e.Err)
+	}
+
+	return fmt.Sprintf("(:serial %s :issuer %q :source %s :revokedAt %s): %s",
+		e.Serial, e.Issuer, e.Source, e.RevokedAt.Format(time.RFC3339), e.Err)
+}
+
+func (e *RevocationError) Unwrap() error { return e.Err }
+
+func (c *ClientAuthTLSConfig) hasRevocation() bool {
+	return len(c.CRLFiles) != 0 || c.OCSP.Enable
+}
+
+func (c *ClientAuthTLSConfig) validateRevocation() error {
+	if v := c.RevocationPolicy; v != "" && v != RevocationPolicyHardFail && v != RevocationPolicySoftFail {
+		return fmt.Errorf("(:revocationPolicy %q): %w", v, ErrRevocationPolicyInvalid)
+	}
+
+	if !c.hasRevocation() {
+		return nil
+	}
+
+	switch c.AuthType.orDefault() {
+	case clientAuthTypeTLSVerifyClientCertIfGiven, clientAuthTypeTLSRequireAndVerifyClientCert:
+	default:
+		return fmt.Errorf("(:authType %s): %w", c.AuthType.orDefault(), ErrRevocationNoVerify)
+	}
+
+	for _, v := range c.CRLFiles {
+		if exists, err := fnspath.IsExists(v); err != nil {
+			return fmt.Errorf("crl-file existence check failed: %w", err)
+		} else if !exists {
+			return fmt.Errorf("error (:path %q): %w", v, ErrCRLFileNotExists)
+		}
+	}
+
+	return c.OCSP.validate()
+}
+
+func loadCRL(path string) (*pkix.CertificateList, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error read crl (:crl %q): %w", path, err)
+	}
+
+	// PEM is handled transparently
+	//nolint: staticcheck
+	crl, err := x509.ParseCRL(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error parse crl (:crl %q): %w", path, err)
+	}
+
+	return crl, nil
+}
+
+type revocationStatus uint8
+
+const (
+	revocationStatusUnknown revocationStatus = iota
+	revocationStatusGood
+	revocationStatusRevoked
+)
+
+// ocspCacheEntry is response or, for short time, failure of responder.
+// done is closed once request is finished, concurrent handshakes
+// wait for it instead of asking responder too.
+type ocspCacheEntry struct {
+	done    chan struct{}
+	resp    *ocsp.Response
+	err     error
+	expires time.Time
+}
+
+func (e *ocspCacheEntry) finished() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// revocationChecker checks leaf of verified client chain
+// against CRLs of CertSource and OCSP responder.
+type revocationChecker struct {
+	cfg    *ClientAuthTLSConfig
+	crls   func() []*pkix.CertificateList
+	client *http.Client
+	fnLog  log.FnT
+
+	mu    sync.Mutex
+	cache map[string]*ocspCacheEntry
+}
+
+func newRevocationChecker(c *ClientAuthTLSConfig, cs *CertSource, fnLog log.FnT) *revocationChecker {
+	return &revocationChecker{
+		cfg:    c,
+		crls:   cs.clientCRLs,
+		client: &http.Client{Timeout: c.OCSP.Timeout},
+		fnLog:  fnLog,
+		cache:  make(map[string]*ocspCacheEntry),
+	}
+}
+
+func (rc *revocationChecker) checkCRL(leaf, issuer *x509.Certificate) (revocationStatus, time.Time, error) {
+	var rdn pkix.RDNSequence
+	if _, err := asn1.Unmarshal(issuer.RawSubject, &rdn); err != nil {
+		return revocationStatusUnknown, time.Time{}, fmt.Errorf("crl: parse issuer: %w", err)
+	}
+
+	issuerName := rdn.String()
+	now := time.Now()
+
+	for _, crl := range rc.crls() {
+		if crl.TBSCertList.Issuer.String() != issuerName {
+			continue
+		}
+
+		//nolint: staticcheck
+		if err := issuer.CheckCRLSignature(crl); err != nil {
+			continue
+		}
+
+		if crl.HasExpired(now) {
+			return revocationStatusUnknown, time.Time{},
+				fmt.Errorf("crl: expired at %s", crl.TBSCertList.NextUpdate.Format(time.RFC3339))
+		}
+
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+				return revocationStatusRevoked, revoked.RevocationTime, nil
+			}
+		}
+
+		return revocationStatusGood, time.Time{}, nil
+	}
+
+	return revocationStatusUnknown, time.Time{}, errors.New("crl: no valid crl of issuer")
+}
+
+func (rc *revocationChecker) responderURL(leaf *x509.Certificate) string {
+	if rc.cfg.OCSP.ResponderURL != "" {
+		return rc.cfg.OCSP.ResponderURL
+	}
+
+	if len(leaf.OCSPServer) != 0 {
+		return leaf.OCSPServer[0]
+	}
+
+	return ""
+}
+
+func (rc *revocationChecker) ocspResponse(leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+	spkiHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	key := hex.EncodeToString(spkiHash[:]) + ":" + leaf.SerialNumber.Text(16)
+
+	rc.mu.Lock()
+
+	if e, ok := rc.cache[key]; ok {
+		if !e.finished() {
+			rc.mu.Unlock()
+			<-e.done
+
+			return e.resp, e.err
+		}
+
+		if time.Now().Before(e.expires) {
+			rc.mu.Unlock()
+
+			return e.resp, e.err
+		}
+
+		delete(rc.cache, key)
+	}
+
+	if len(rc.cache) >= clientAuthOCSPCacheSize {
+		rc.evict()
+	}
+
+	e := &ocspCacheEntry{done: make(chan struct{})}
+	rc.cache[key] = e
+	rc.mu.Unlock()
+
+	e.resp, e.err = rc.requestOCSP(leaf, issuer)
+	now := time.Now()
+
+	if e.err != nil {
+		// responder outage must not stall every handshake for timeout
+		e.expires = now.Add(clientAuthOCSPFailureCacheTTL)
+		if ttl := rc.cfg.OCSP.CacheTTL; ttl < clientAuthOCSPFailureCacheTTL {
+			e.expires = now.Add(ttl)
+		}
+	} else {
+		e.expires = now.Add(rc.cfg.OCSP.CacheTTL)
+		if !e.resp.NextUpdate.IsZero() && e.resp.NextUpdate.Before(e.expires) {
+			e.expires = e.resp.NextUpdate
+		}
+	}
+
+	close(e.done)
+
+	return e.resp, e.err
+}
+
+// evict removes expired entries and, if cache is still full,
+// arbitrary finished ones. Requests in flight are kept.
+// Must be called with rc.mu held.
+func (rc *revocationChecker) evict() {
+	now := time.Now()
+
+	for key, e := range rc.cache {
+		if e.finished() && !now.Before(e.expires) {
+			delete(rc.cache, key)
+		}
+	}
+
+	for key, e := range rc.cache {
+		if len(rc.cache) < clientAuthOCSPCacheSize {
+			return
+		}
+
+		if e.finished() {
+			delete(rc.cache, key)
+		}
+	}
+}
+
+func (rc *revocationChecker) requestOCSP(leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+	responder := rc.responderURL(leaf)
+	if responder == "" {
+		return nil, errors.New("ocsp: no responder")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rc.cfg.OCSP.Timeout)
+	defer cancel()
+
+	_, resp, err := requestOCSP(ctx, rc.client, responder, leaf, issuer)
+
+	return resp, err
+}
+
+func (rc *revocationChecker) checkOCSP(leaf, issuer *x509.Certificate) (revocationStatus, time.Time, error) {
+	resp, err := rc.ocspResponse(leaf, issuer)
+	if err != nil {
+		return revocationStatusUnknown, time.Time{}, err
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return revocationStatusGood, time.Time{}, nil
+	case ocsp.Revoked:
+		return revocationStatusRevoked, resp.RevokedAt, nil
+	default:
+		return revocationStatusUnknown, time.Time{}, errors.New("ocsp: status unknown")
+	}
+}
+
+// check returns RevocationError if leaf is revoked by any source,
+// or no source knows its status (and policy is hard-fail).
+func (rc *revocationChecker) check(leaf, issuer *x509.Certificate) error {
+	type source struct {
+		name  string
+		check func(leaf, issuer *x509.Certificate) (revocationStatus, time.Time, error)
+	}
+
+	var sources []source
+
+	if len(rc.cfg.CRLFiles) != 0 {
+		sources = append(sources, source{RevocationSourceCRL, rc.checkCRL})
+	}
+
+	if rc.cfg.OCSP.Enable {
+		sources = append(sources, source{RevocationSourceOCSP, rc.checkOCSP})
+	}
+
+	var (
+		good     bool
+		unknowns []error
+	)
+
+	for _, s := range sources {
+		status, revokedAt, err := s.check(leaf, issuer)
+
+		switch status {
+		case revocationStatusRevoked:
+			return &RevocationError{
+				Serial:    leaf.SerialNumber.Text(16),
+				Issuer:    issuer.Subject.String(),
+				Source:    s.name,
+				RevokedAt: revokedAt,
+				Err:       ErrClientCertRevoked,
+			}
+		case revocationStatusGood:
+			good = true
+		case revocationStatusUnknown:
+			unknowns = append(unknowns, err)
+		}
+	}
+
+	if good || len(unknowns) == 0 {
+		return nil
+	}
+
+	err := &RevocationError{
+		Serial: leaf.SerialNumber.Text(16),
+		Issuer: issuer.Subject.String(),
+		Err:    fmt.Errorf("%s: %w", joinErrors(unknowns...), ErrRevocationStatusUnknown),
+	}
+
+	if rc.cfg.RevocationPolicy == RevocationPolicySoftFail {
+		rc.fnLog(log.Warn, "client auth tls revocation (:policy %s) accepted: %s", rc.cfg.RevocationPolicy, err)
+		return nil
+	}
+
+	return err
+}
+
+// verifyConnection checks leaf of first verified chain.
+// Unlike VerifyPeerCertificate it's called on resumed sessions too,
+// so CRLs reloaded since first handshake apply to them.
+// Without verified chains (no certificate given) auth-type decides.
+func (rc *revocationChecker) verifyConnection(state tls.ConnectionState) error {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	chain := state.VerifiedChains[0]
+	leaf, issuer := chain[0], chain[0]
+
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+
+	if err := rc.check(leaf, issuer); err != nil {
+		err := newClientCertError(leaf, err)
+		rc.fnLog(log.Warn, "client auth tls rejected: %s", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// applyRevocation checks revocation on every handshake, resumed ones included.
+func (c *ClientAuthTLSConfig) applyRevocation(tlsConfig *tls.Config, cs *CertSource, fnLog log.FnT) {
+	if !c.hasRevocation() {
+		return
+	}
+
+	tlsConfig.VerifyConnection = newRevocationChecker(c, cs, fnLog).verifyConnection
+}
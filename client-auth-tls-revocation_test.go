@@ -0,0 +1,476 @@
+package servers_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"golang.org/x/crypto/ocsp"
+)
+
+func writeTestCRL(t *testing.T, path string, ca *testCert, nextUpdate time.Time, revoked ...*testCert) {
+	t.Helper()
+
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: nextUpdate,
+	}
+
+	for _, c := range revoked {
+		tmpl.RevokedCertificates = append(tmpl.RevokedCertificates, pkix.RevokedCertificate{
+			SerialNumber:   c.cert.SerialNumber,
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("create crl: %s", err)
+	}
+
+	writeTestFile(t, path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}))
+}
+
+// newTestOCSPResponder answers with status of serial valid for validity,
+// unknown serials get 500.
+func newTestOCSPResponder(t *testing.T, ca *testCert, validity time.Duration,
+	statuses map[string]int,
+) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var requests int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status, ok := statuses[req.SerialNumber.String()]
+		if !ok {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now(),
+			NextUpdate:   time.Now().Add(validity),
+			RevokedAt:    time.Now().Add(-time.Hour),
+		}, ca.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+func getWithClientCert(addr string, pool *x509.CertPool, cert tls.Certificate) error {
+	client := http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS13,
+		}},
+	}
+
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// getResumable is GET over TLS 1.3 connection resuming session of sessions if any,
+// reports whether session was resumed.
+func getResumable(addr string, pool *x509.CertPool, cert tls.Certificate,
+	sessions tls.ClientSessionCache,
+) (bool, error) {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		RootCAs:            pool,
+		Certificates:       []tls.Certificate{cert},
+		ClientSessionCache: sessions,
+		MinVersion:         tls.VersionTLS13,
+	})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// client certificate is rejected (and session ticket is sent) after handshake
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"); err != nil {
+		return false, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return false, err
+	}
+
+	return conn.ConnectionState().DidResume, nil
+}
+
+func TestClientAuthTLSRevocationCRL(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	good := newTestCert(t, dir, "good", ca, testCertOpts{cn: "good", isClient: true})
+	revoked := newTestCert(t, dir, "revoked", ca, testCertOpts{cn: "revoked", isClient: true})
+
+	// CA unknown to CRLs
+	otherCA := newTestCert(t, dir, "other-ca", nil, testCertOpts{cn: "other-ca", isCA: true})
+	other := newTestCert(t, dir, "other", otherCA, testCertOpts{cn: "other", isClient: true})
+
+	crlFile := filepath.Join(dir, "ca.crl")
+	writeTestCRL(t, crlFile, ca, time.Now().Add(time.Hour), revoked)
+
+	caBundle := filepath.Join(dir, "ca-bundle.crt")
+	writeTestFile(t, caBundle, append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCA.cert.Raw})...))
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    reload:
+      enable: true
+      interval: 20ms
+      sighup: false
+  clientAuth:
+    tls:
+      enable: true
+      authType: require-and-verify-client-cert
+      caCertFile: %q
+      crlFiles: [%q]`, srv.certFile, srv.keyFile, caBundle, crlFile))
+
+	lg := testLog{}
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx), servers.FnLog(lg.fn))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	if err := getWithClientCert(addr, pool, good.tlsCertificate(t)); err != nil {
+		t.Errorf("good: expected client to be allowed: %s", err)
+	}
+
+	if err := getWithClientCert(addr, pool, revoked.tlsCertificate(t)); err == nil {
+		t.Errorf("revoked: expected client to be rejected")
+	}
+
+	var revErr *servers.RevocationError
+	if !lg.errorAs(&revErr) || !errors.Is(revErr, servers.ErrClientCertRevoked) ||
+		revErr.Source != servers.RevocationSourceCRL || revErr.Serial != revoked.cert.SerialNumber.Text(16) {
+		t.Errorf("expected crl revocation error logged, got %q", lg.msgs)
+	}
+
+	// hard-fail: no CRL of issuer
+	if err := getWithClientCert(addr, pool, other.tlsCertificate(t)); err == nil {
+		t.Errorf("other: expected client to be rejected")
+	}
+
+	var certErr *servers.ClientCertError
+	if !lg.errorAs(&certErr) || !lg.contains(servers.ErrRevocationStatusUnknown.Error()) {
+		t.Errorf("expected unknown revocation status logged, got %q", lg.msgs)
+	}
+
+	// session of good client is resumable
+	sessions := tls.NewLRUClientSessionCache(1)
+
+	if _, err := getResumable(addr, pool, good.tlsCertificate(t), sessions); err != nil {
+		t.Fatalf("good: expected client to be allowed: %s", err)
+	}
+
+	if resumed, err := getResumable(addr, pool, good.tlsCertificate(t), sessions); err != nil || !resumed {
+		t.Fatalf("good: expected session to be resumed (:resumed %t): %v", resumed, err)
+	}
+
+	// CRL is reloaded with certificate files
+	writeTestCRL(t, crlFile, ca, time.Now().Add(time.Hour), revoked, good)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for getWithClientCert(addr, pool, good.tlsCertificate(t)) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("revoked by reloaded crl client is still allowed")
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// resumed session is checked against reloaded CRL as well
+	if _, err := getResumable(addr, pool, good.tlsCertificate(t), sessions); err == nil {
+		t.Errorf("revoked by reloaded crl client is allowed on resumed session")
+	}
+}
+
+func TestClientAuthTLSRevocationOCSP(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	good := newTestCert(t, dir, "good", ca, testCertOpts{cn: "good", isClient: true})
+	revoked := newTestCert(t, dir, "revoked", ca, testCertOpts{cn: "revoked", isClient: true})
+	unavailable := newTestCert(t, dir, "unavailable", ca, testCertOpts{cn: "unavailable", isClient: true})
+
+	responder, requests := newTestOCSPResponder(t, ca, time.Hour, map[string]int{
+		good.cert.SerialNumber.String():    ocsp.Good,
+		revoked.cert.SerialNumber.String(): ocsp.Revoked,
+	})
+
+	server := func(policy string) string {
+		return fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+  clientAuth:
+    tls:
+      enable: true
+      authType: require-and-verify-client-cert
+      caCertFile: %q
+      ocsp:
+        enable: true
+        responderURL: %q
+      revocationPolicy: %s`, srv.certFile, srv.keyFile, ca.certFile, responder.URL, policy)
+	}
+
+	ss := newTestServers(t, server(servers.RevocationPolicyHardFail)+"\n"+server(servers.RevocationPolicySoftFail))
+
+	lg := testLog{}
+	listeners := listenTestServers(t, ss)
+	hard := listenerAddr(t, listeners, ss[0].Server)
+	soft := listenerAddr(t, listeners, ss[1].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx), servers.FnLog(lg.fn))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	for i := 0; i < 3; i++ {
+		if err := getWithClientCert(hard, pool, good.tlsCertificate(t)); err != nil {
+			t.Fatalf("good: expected client to be allowed: %s", err)
+		}
+	}
+
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("expected response to be cached, got %d requests", n)
+	}
+
+	for _, addr := range []string{hard, soft} {
+		if err := getWithClientCert(addr, pool, revoked.tlsCertificate(t)); err == nil {
+			t.Errorf("revoked: expected client to be rejected on %s", addr)
+		}
+	}
+
+	var revErr *servers.RevocationError
+	if !lg.errorAs(&revErr) || !errors.Is(revErr, servers.ErrClientCertRevoked) ||
+		revErr.Source != servers.RevocationSourceOCSP || revErr.RevokedAt.IsZero() {
+		t.Errorf("expected ocsp revocation error logged, got %q", lg.msgs)
+	}
+
+	before := atomic.LoadInt32(requests)
+
+	for i := 0; i < 3; i++ {
+		if err := getWithClientCert(hard, pool, unavailable.tlsCertificate(t)); err == nil {
+			t.Errorf("unavailable: expected client to be rejected by hard-fail")
+		}
+	}
+
+	// failure is cached for a while, responder is not asked on every handshake
+	if n := atomic.LoadInt32(requests) - before; n != 1 {
+		t.Errorf("expected failure to be cached, got %d requests", n)
+	}
+
+	if err := getWithClientCert(soft, pool, unavailable.tlsCertificate(t)); err != nil {
+		t.Errorf("unavailable: expected client to be allowed by soft-fail: %s", err)
+	}
+
+	if !lg.contains("(:policy soft-fail) accepted") {
+		t.Errorf("expected soft-fail to be logged, got %q", lg.msgs)
+	}
+}
+
+func TestClientAuthTLSRevocationOCSPConcurrent(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	good := newTestCert(t, dir, "good", ca, testCertOpts{cn: "good", isClient: true})
+
+	responder, requests := newTestOCSPResponder(t, ca, time.Hour, map[string]int{
+		good.cert.SerialNumber.String(): ocsp.Good,
+	})
+
+	responderURL, err := url.Parse(responder.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// slow enough for handshakes to miss cache together
+	proxy := httputil.NewSingleHostReverseProxy(responderURL)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		proxy.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+  clientAuth:
+    tls:
+      enable: true
+      authType: require-and-verify-client-cert
+      caCertFile: %q
+      ocsp:
+        enable: true
+        responderURL: %q`, srv.certFile, srv.keyFile, ca.certFile, slow.URL))
+
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx), servers.FnLog((&testLog{}).fn))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	cert := good.tlsCertificate(t)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := getWithClientCert(addr, pool, cert); err != nil {
+				t.Errorf("expected client to be allowed: %s", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("expected concurrent handshakes to share request, got %d requests", n)
+	}
+}
+
+func TestClientAuthTLSRevocationValidate(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server"})
+
+	for i, tt := range []struct {
+		clientAuth string
+		err        error
+	}{
+		{"authType: require-and-verify-client-cert\n      ocsp: {enable: true}", nil},
+		{"authType: require-any-client-cert\n      ocsp: {enable: true}", servers.ErrRevocationNoVerify},
+		{"authType: verify-client-cert-if-given\n      crlFiles: [/nonexistent.crl]", servers.ErrCRLFileNotExists},
+		{"authType: verify-client-cert-if-given\n      revocationPolicy: never", servers.ErrRevocationPolicyInvalid},
+		{"authType: verify-client-cert-if-given\n      ocsp: {enable: true, responderURL: \"ldap://ocsp\"}", servers.ErrOCSPResponderURLInvalid},
+		{"authType: verify-client-cert-if-given\n      ocsp: {enable: true, timeout: -1s}", servers.ErrOCSPNegativeValue},
+	} {
+		var ss servers.Servers
+
+		raw := fmt.Sprintf(`- kind: [inet, http]
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+  clientAuth:
+    tls:
+      enable: true
+      caCertFile: %q
+      %s`, srv.certFile, srv.keyFile, ca.certFile, tt.clientAuth)
+
+		if err := yamlUnmarshal(raw, &ss); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+
+		ss.Defaultize("127.0.0.1", 0, "")
+
+		if err := ss.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("%d: expected %v, got %v", i, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package servers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-x-pkg/log"
+)
+
+const spiffeScheme = "spiffe"
+
+// ClientCertError is returned from TLS handshake when client certificate
+// doesn't match any of configured allow-lists
+// (clientCommonNames, clientDNSNames, clientURIs, clientSPIFFEIDs).
+type ClientCertError struct {
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+
+	Err error
+}
+
+func (e *ClientCertError) Error() string {
+	return fmt.Sprintf("client certificate (:cn %q :dns %s :uri %s): %s",
+		e.CommonName, e.DNSNames, e.URIs, e.Err)
+}
+
+func (e *ClientCertError) Unwrap() error { return e.Err }
+
+func newClientCertError(cert *x509.Certificate, err error) *ClientCertError {
+	e := &ClientCertError{Err: err}
+
+	if cert == nil {
+		return e
+	}
+
+	e.CommonName = cert.Subject.CommonName
+	e.DNSNames = cert.DNSNames
+
+	for _, u := range cert.URIs {
+		e.URIs = append(e.URIs, u.String())
+	}
+
+	return e
+}
+
+func (c *ClientAuthTLSConfig) hasAllowList() bool {
+	return len(c.ClientCommonNames) != 0 ||
+		len(c.ClientDNSNames) != 0 ||
+		len(c.ClientURIs) != 0 ||
+		len(c.ClientSPIFFEIDs) != 0
+}
+
+// isAllowed reports whether certificate matches at least one entry
+// of at least one allow-list.
+func (c *ClientAuthTLSConfig) isAllowed(cert *x509.Certificate) bool {
+	for _, cn := range c.ClientCommonNames {
+		if cert.Subject.CommonName == cn {
+			return true
+		}
+	}
+
+	for _, name := range c.ClientDNSNames {
+		for _, dns := range cert.DNSNames {
+			if strings.EqualFold(dns, name) {
+				return true
+			}
+		}
+	}
+
+	for _, u := range cert.URIs {
+		raw := u.String()
+
+		for _, v := range c.ClientURIs {
+			if raw == v {
+				return true
+			}
+		}
+
+		if u.Scheme != spiffeScheme {
+			continue
+		}
+
+		for _, v := range c.ClientSPIFFEIDs {
+			if raw == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *ClientAuthTLSConfig) validateAllowList() error {
+	if !c.hasAllowList() {
+		return nil
+	}
+
+	switch c.AuthType.orDefault() {
+	case clientAuthTypeTLSNoClientCert:
+		return fmt.Errorf("(:authType %s): %w", c.AuthType.orDefault(), ErrClientAllowListNoClientCert)
+	case clientAuthTypeTLSRequestClientCert, clientAuthTypeTLSRequireAnyClientCert:
+		// unverified certificate names are chosen by client itself
+		return fmt.Errorf("(:authType %s): %w", c.AuthType.orDefault(), ErrClientAllowListNoVerify)
+	}
+
+	for _, v := range c.ClientURIs {
+		if _, err := url.Parse(v); err != nil {
+			return fmt.Errorf("client uri %q: %w", v, err)
+		}
+	}
+
+	for _, v := range c.ClientSPIFFEIDs {
+		if u, err := url.Parse(v); err != nil {
+			return fmt.Errorf("client spiffe-id %q: %w", v, err)
+		} else if u.Scheme != spiffeScheme || u.Host == "" {
+			return fmt.Errorf("client spiffe-id %q: %w", v, ErrInvalidSPIFFEID)
+		}
+	}
+
+	return nil
+}
+
+// newVerifyPeerCertificate returns tls.Config.VerifyPeerCertificate callback
+// rejecting clients not matched by allow-lists.
+// It's called after chain verification, only verified certificate
+// is matched: names of unverified one are chosen by client itself.
+// Client without certificate is rejected too.
+func (c *ClientAuthTLSConfig) newVerifyPeerCertificate(
+	fnLog log.FnT,
+) func([][]byte, [][]*x509.Certificate) error {
+	if !c.hasAllowList() {
+		return nil
+	}
+
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			// verify-client-cert-if-given must not let anonymous
+			// client bypass allow-list
+			err := newClientCertError(nil, ErrClientCertNotGiven)
+			fnLog(log.Warn, "client auth tls rejected: %s", err)
+
+			return err
+		}
+
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			// parsed for log only
+			cert, _ := x509.ParseCertificate(rawCerts[0])
+
+			err := newClientCertError(cert, ErrClientCertNotVerified)
+			fnLog(log.Warn, "client auth tls rejected: %s", err)
+
+			return err
+		}
+
+		cert := verifiedChains[0][0]
+
+		if c.isAllowed(cert) {
+			return nil
+		}
+
+		err := newClientCertError(cert, ErrClientCertNotAllowed)
+		fnLog(log.Warn, "client auth tls rejected: %s", err)
+
+		return err
+	}
+}
+
+// apply sets client auth policy. CA pool is provided by CertSource.
+func (c *ClientAuthTLSConfig) apply(tlsConfig *tls.Config, fnLog log.FnT) {
+	tlsConfig.ClientAuth = c.AuthType.orDefault().CryptoTLSClientAuthType()
+	tlsConfig.VerifyPeerCertificate = c.newVerifyPeerCertificate(fnLog)
+}
@@ -0,0 +1,291 @@
+package servers_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	xlog "github.com/go-x-pkg/log"
+	"github.com/go-x-pkg/servers"
+)
+
+type testLog struct {
+	mu   sync.Mutex
+	msgs []string
+	errs []error
+}
+
+func (l *testLog) fn(_ xlog.Level, msg string, a ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.msgs = append(l.msgs, fmt.Sprintf(msg, a...))
+
+	for _, v := range a {
+		if err, ok := v.(error); ok {
+			l.errs = append(l.errs, err)
+		}
+	}
+}
+
+// errorAs reports whether any logged error matches target (errors.As).
+func (l *testLog) errorAs(target interface{}) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, err := range l.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *testLog) contains(sub string) bool { return l.count(sub) != 0 }
+
+func (l *testLog) count(sub string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+
+	for _, msg := range l.msgs {
+		if strings.Contains(msg, sub) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestClientAuthTLSAllowList(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+
+	clients := []struct {
+		name  string
+		opts  testCertOpts
+		allow bool
+	}{
+		{"cn", testCertOpts{cn: "billing", isClient: true}, true},
+		{"dns", testCertOpts{cn: "x", dns: []string{"Orders.internal"}, isClient: true}, true},
+		{"spiffe", testCertOpts{cn: "y", uris: []string{"spiffe://example.org/ns/default/sa/api"}, isClient: true}, true},
+		{"denied", testCertOpts{cn: "intruder", dns: []string{"evil.internal"}, isClient: true}, false},
+	}
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+  clientAuth:
+    tls:
+      enable: true
+      authType: require-and-verify-client-cert
+      caCertFile: %q
+      clientCommonNames: [billing]
+      clientDNSNames: [orders.internal]
+      clientSPIFFEIDs: ["spiffe://example.org/ns/default/sa/api"]`,
+		srv.certFile, srv.keyFile, ca.certFile))
+
+	lg := testLog{}
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(func(servers.Server) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		}, servers.Context(ctx), servers.FnLog(lg.fn))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	for _, tt := range clients {
+		c := newTestCert(t, dir, "client-"+tt.name, ca, tt.opts)
+
+		client := http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      pool,
+				Certificates: []tls.Certificate{c.tlsCertificate(t)},
+				MinVersion:   tls.VersionTLS13,
+			}},
+		}
+
+		resp, err := client.Get("https://" + addr + "/")
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if tt.allow && err != nil {
+			t.Errorf("%s: expected client to be allowed: %s", tt.name, err)
+		} else if !tt.allow && err == nil {
+			t.Errorf("%s: expected client to be rejected", tt.name)
+		}
+	}
+
+	if !lg.contains(`:cn "intruder"`) {
+		t.Errorf("expected rejected client to be logged, got %q", lg.msgs)
+	}
+}
+
+func TestClientAuthTLSAllowListNoClientCert(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	allowed := newTestCert(t, dir, "client", ca, testCertOpts{cn: "billing", isClient: true})
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+  clientAuth:
+    tls:
+      enable: true
+      authType: verify-client-cert-if-given
+      caCertFile: %q
+      clientCommonNames: [billing]`, srv.certFile, srv.keyFile, ca.certFile))
+
+	lg := testLog{}
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx), servers.FnLog(lg.fn))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	if err := getWithClientCert(addr, pool, allowed.tlsCertificate(t)); err != nil {
+		t.Errorf("expected allowed client to be accepted: %s", err)
+	}
+
+	if err := getWithClientCert(addr, pool, tls.Certificate{}); err == nil {
+		t.Errorf("expected client without certificate to be rejected")
+	}
+
+	if !lg.errorAs(new(*servers.ClientCertError)) || !lg.contains(servers.ErrClientCertNotGiven.Error()) {
+		t.Errorf("expected rejection to be logged, got %q", lg.msgs)
+	}
+}
+
+func TestClientAuthTLSAllowListValidate(t *testing.T) {
+	tests := []struct {
+		raw string
+	}{
+		{`- kind: inet
+  tls:
+    enable: true
+    certFile: /dev/null
+    keyFile: /dev/null
+  clientAuth:
+    tls:
+      enable: true
+      clientCommonNames: [foo]`},
+
+		{`- kind: inet
+  tls:
+    enable: true
+    certFile: /dev/null
+    keyFile: /dev/null
+  clientAuth:
+    tls:
+      enable: true
+      authType: require-and-verify-client-cert
+      clientSPIFFEIDs: ["https://example.org/foo"]`},
+	}
+
+	for i, tt := range tests {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(tt.raw, &ss); err != nil {
+			t.Fatalf("%d: unmarshal yaml: %s", i, err)
+		}
+
+		ss.Defaultize("127.0.0.1", 0, "")
+
+		if err := ss.Validate(); err == nil {
+			t.Errorf("%d: expected validation error", i)
+		}
+	}
+}
+
+func TestClientAuthTLSAllowListUnverified(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	// self-signed, allowed name
+	forged := newTestCert(t, dir, "forged", nil, testCertOpts{cn: "billing", isClient: true})
+
+	var ss servers.Servers
+
+	if err := yamlUnmarshal(fmt.Sprintf(`- kind: [inet, http]
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+  clientAuth:
+    tls:
+      enable: true
+      authType: require-any-client-cert
+      clientCommonNames: [billing]`, srv.certFile, srv.keyFile), &ss); err != nil {
+		t.Fatal(err)
+	}
+
+	ss.Defaultize("127.0.0.1", 0, "")
+
+	if err := ss.Validate(); !errors.Is(err, servers.ErrClientAllowListNoVerify) {
+		t.Errorf("expected %v, got %v", servers.ErrClientAllowListNoVerify, err)
+	}
+
+	// served anyway, unverified certificate is never matched
+	lg := testLog{}
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx), servers.FnLog(lg.fn))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	if err := getWithClientCert(addr, pool, forged.tlsCertificate(t)); err == nil {
+		t.Errorf("expected self-signed client to be rejected")
+	}
+
+	if !lg.errorAs(new(*servers.ClientCertError)) || !lg.contains(servers.ErrClientCertNotVerified.Error()) {
+		t.Errorf("expected rejection to be logged, got %q", lg.msgs)
+	}
+}
@@ -0,0 +1,152 @@
+package servers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+)
+
+// ClientIdentity is verified client certificate of mTLS connection
+// (clientAuth.tls), attached to request context by ServeHTTP,
+// ServeGRPC and ServeMux. Certificates not verified
+// (request-client-cert, require-any-client-cert) are never attached.
+type ClientIdentity struct {
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []string
+	// SPIFFEID is first spiffe:// SAN URI, if any.
+	SPIFFEID string
+	// Fingerprint is hex SHA-256 of certificate DER.
+	Fingerprint string
+	Issuer      string
+
+	Certificate *x509.Certificate
+}
+
+// newClientIdentity returns identity of verified peer certificate,
+// nil if client sent none or it's not verified.
+func newClientIdentity(state *tls.ConnectionState) *ClientIdentity {
+	if state == nil || len(state.PeerCertificates) == 0 || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+
+	cert := state.PeerCertificates[0]
+	sum := sha256.Sum256(cert.Raw)
+
+	id := &ClientIdentity{
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		Fingerprint:    hex.EncodeToString(sum[:]),
+		Issuer:         cert.Issuer.String(),
+		Certificate:    cert,
+	}
+
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+
+		if id.SPIFFEID == "" && u.Scheme == spiffeScheme {
+			id.SPIFFEID = u.String()
+		}
+	}
+
+	return id
+}
+
+type clientIdentityKey struct{}
+
+// ContextWithClientIdentity returns ctx carrying id.
+// Useful to test handlers without TLS.
+func ContextWithClientIdentity(ctx context.Context, id *ClientIdentity) context.Context {
+	return context.WithValue(ctx, clientIdentityKey{}, id)
+}
+
+// ClientIdentityFromContext returns identity of mTLS client
+// of HTTP request or gRPC call context.
+func ClientIdentityFromContext(ctx context.Context) (*ClientIdentity, bool) {
+	id, ok := ctx.Value(clientIdentityKey{}).(*ClientIdentity)
+
+	return id, ok && id != nil
+}
+
+// connClientIdentity is resolved on first request of connection,
+// http.Server.ConnContext is called before TLS handshake.
+type connClientIdentity struct {
+	once sync.Once
+	id   *ClientIdentity
+}
+
+type connClientIdentityKey struct{}
+
+func clientIdentityConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connClientIdentityKey{}, &connClientIdentity{})
+}
+
+// withClientIdentity attaches client identity to request context.
+// Server must have ConnContext set to clientIdentityConnContext.
+func withClientIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(connClientIdentityKey{}).(*connClientIdentity)
+		if !ok || r.TLS == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		conn.once.Do(func() { conn.id = newClientIdentity(r.TLS) })
+
+		if conn.id != nil {
+			r = r.WithContext(ContextWithClientIdentity(r.Context(), conn.id))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// applyClientIdentity makes TLS server attach client identity
+// to request contexts.
+func applyClientIdentity(server *http.Server) {
+	server.ConnContext = clientIdentityConnContext
+	server.Handler = withClientIdentity(server.Handler)
+}
+
+// clientIdentityStatsHandler attaches client identity
+// to gRPC connection context, once per connection.
+type clientIdentityStatsHandler struct{}
+
+func (clientIdentityStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx
+	}
+
+	if id := newClientIdentity(&info.State); id != nil {
+		return ContextWithClientIdentity(ctx, id)
+	}
+
+	return ctx
+}
+
+func (clientIdentityStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (clientIdentityStatsHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (clientIdentityStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
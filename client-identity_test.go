@@ -0,0 +1,203 @@
+package servers_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func formatClientIdentity(id *servers.ClientIdentity, ok bool) string {
+	if !ok {
+		return "none"
+	}
+
+	return fmt.Sprintf("%s %s %s %s", id.CommonName, id.SPIFFEID, id.Fingerprint, id.Issuer)
+}
+
+func TestClientIdentity(t *testing.T) {
+	for _, tt := range []struct {
+		authType string
+		verified bool
+	}{
+		{"verify-client-cert-if-given", true},
+		{"request-client-cert", false},
+	} {
+		t.Run(tt.authType, func(t *testing.T) { testClientIdentity(t, tt.authType, tt.verified) })
+	}
+}
+
+// testClientIdentity serves http, grpc and mux, identity is attached
+// only if certificate is verified.
+func testClientIdentity(t *testing.T, authType string, verified bool) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	client := newTestCert(t, dir, "client", ca, testCertOpts{
+		cn: "client", isClient: true,
+		dns:  []string{"client.test"},
+		uris: []string{"https://client.test/", "spiffe://example.org/client"},
+	})
+
+	tlsConfig := fmt.Sprintf(`
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+  clientAuth:
+    tls:
+      enable: true
+      authType: %s
+      caCertFile: %q`, srv.certFile, srv.keyFile, authType, ca.certFile)
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1%s
+- kind: [inet, grpc]
+  host: 127.0.0.1%s
+  health:
+    enable: true
+- kind: [inet, http, grpc]
+  host: 127.0.0.1%s
+  health:
+    enable: true`, tlsConfig, tlsConfig, tlsConfig))
+
+	listeners := listenTestServers(t, ss)
+	httpAddr := listenerAddr(t, listeners, ss[0].Server)
+	grpcAddr := listenerAddr(t, listeners, ss[1].Server)
+	muxAddr := listenerAddr(t, listeners, ss[2].Server)
+
+	grpcIdentities := make(chan string, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.Serve(
+			func(servers.Server) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					id, ok := servers.ClientIdentityFromContext(r.Context())
+					fmt.Fprintf(w, "%p %s", id, formatClientIdentity(id, ok))
+				})
+			},
+			func(_ servers.Server, opts ...grpc.ServerOption) *grpc.Server {
+				return grpc.NewServer(append(opts, grpc.ChainUnaryInterceptor(func(
+					ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+				) (interface{}, error) {
+					grpcIdentities <- formatClientIdentity(servers.ClientIdentityFromContext(ctx))
+
+					return handler(ctx, req)
+				}))...)
+			},
+			servers.Context(ctx),
+		)
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	sum := sha256.Sum256(client.cert.Raw)
+	expected := fmt.Sprintf("client spiffe://example.org/client %s CN=ca", hex.EncodeToString(sum[:]))
+
+	if !verified {
+		expected = "none"
+	}
+
+	for _, tt := range []struct {
+		name     string
+		certs    []tls.Certificate
+		expected string
+	}{
+		{"client cert", []tls.Certificate{client.tlsCertificate(t)}, expected},
+		{"no client cert", nil, "none"},
+	} {
+		tlsClientConfig := &tls.Config{RootCAs: pool, Certificates: tt.certs, MinVersion: tls.VersionTLS13}
+
+		httpClient := &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsClientConfig, ForceAttemptHTTP2: true},
+		}
+
+		var prev string
+
+		// same connection, same identity
+		for i := 0; i < 2; i++ {
+			resp, err := httpClient.Get("https://" + httpAddr + "/")
+			if err != nil {
+				t.Fatalf("%s: http: %s", tt.name, err)
+			}
+
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			parts := strings.SplitN(string(body), " ", 2)
+			if len(parts) != 2 {
+				t.Fatalf("%s: unexpected body %q", tt.name, body)
+			}
+
+			ptr, got := parts[0], parts[1]
+
+			if got != tt.expected {
+				t.Errorf("%s: http: expected %q, got %q", tt.name, tt.expected, got)
+			}
+
+			if i != 0 && ptr != prev {
+				t.Errorf("%s: http: identity is extracted per request", tt.name)
+			}
+
+			prev = ptr
+		}
+
+		for _, addr := range []string{grpcAddr, muxAddr} {
+			conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsClientConfig)))
+			if err != nil {
+				t.Fatalf("%s: dial %s: %s", tt.name, addr, err)
+			}
+
+			ctxTimeout, cancelTimeout := context.WithTimeout(ctx, 5*time.Second)
+
+			if _, err := healthpb.NewHealthClient(conn).Check(ctxTimeout, &healthpb.HealthCheckRequest{}); err != nil {
+				t.Errorf("%s: grpc %s: %s", tt.name, addr, err)
+			}
+
+			cancelTimeout()
+			conn.Close()
+
+			select {
+			case got := <-grpcIdentities:
+				if got != tt.expected {
+					t.Errorf("%s: grpc %s: expected %q, got %q", tt.name, addr, tt.expected, got)
+				}
+			default:
+				t.Errorf("%s: grpc %s: interceptor is not called", tt.name, addr)
+			}
+		}
+	}
+}
+
+func TestClientIdentityContext(t *testing.T) {
+	if _, ok := servers.ClientIdentityFromContext(context.Background()); ok {
+		t.Errorf("unexpected identity of empty context")
+	}
+
+	ctx := servers.ContextWithClientIdentity(context.Background(), &servers.ClientIdentity{CommonName: "client"})
+
+	if id, ok := servers.ClientIdentityFromContext(ctx); !ok || id.CommonName != "client" {
+		t.Errorf("unexpected identity %v", id)
+	}
+}
@@ -10,17 +10,48 @@ import (
 const (
 	defaultUNIXSocketFileMode os.FileMode = 0o666
 
+	defaultHealthLivenessPath  = "/healthz"
+	defaultHealthReadinessPath = "/readyz"
+
+	defaultMetricsPath = "/metrics"
+
+	// AWS NLB and HAProxy send header right after connect.
+	defaultProxyProtocolReadHeaderTimeout = 5 * time.Second
+
 	// defaultPprofPrefix url prefix of pprof.
 	defaultPprofPrefix = "/debug/pprof"
 
 	// Potential slowloris attack GO-S2112.
 	defaultReadHeaderTimeout = 3 * time.Second
 
+	defaultHTTPIdleTimeout = 2 * time.Minute
+
+	// same as golang.org/x/net/http2 default.
+	defaultHTTP2MaxConcurrentStreams = 250
+
 	defaultTLSPreferServerCipherSuites = true
 
+	defaultTLSReloadInterval = time.Minute
+	defaultTLSReloadSIGHUP   = true
+
+	defaultTLSExpiryWarning = 30 * 24 * time.Hour
+
+	defaultOCSPStaplingTimeout       = 10 * time.Second
+	defaultOCSPStaplingRetryInterval = time.Minute
+
 	defaultVersionTLS = versionTLS13
 
 	defaultClientAuthTypeTLS = clientAuthTypeTLSNoClientCert
+
+	defaultClientAuthOCSPTimeout  = 5 * time.Second
+	defaultClientAuthOCSPCacheTTL = time.Hour
+
+	// failed responder requests are cached for at most this long.
+	clientAuthOCSPFailureCacheTTL = 10 * time.Second
+	// max number of cached responses (client certificates) per listener.
+	clientAuthOCSPCacheSize = 10000
+
+	defaultUpgradeReadyTimeout = 30 * time.Second
 )
 
 var (
@@ -1,6 +1,9 @@
 package servers
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	ErrUnmarshalUnknownKind = errors.New("unknown server-kind, no server associated with kind")
@@ -14,13 +17,168 @@ var (
 	ErrUnixSocketParentDirNotExists = errors.New("unix socket parent dir doesn't exists")
 	ErrUnixSocketPathNotProvided    = errors.New("tls key-file path is not provided")
 
+	ErrUnixSocketPathNotSocket = errors.New("unix socket path exists and is not a socket")
+	ErrUnixSocketInUse         = errors.New("unix socket is in use by another process")
+
+	ErrUnixAbstractNotSupported = errors.New("abstract unix sockets are linux only")
+	ErrUnixAbstractOwnership    = errors.New("abstract unix socket has no owner or group")
+
+	ErrUnixTLSNotSupported        = errors.New("tls is not supported on unix socket")
+	ErrUnixClientAuthNotSupported = errors.New("client auth is not supported on unix socket")
+
+	ErrListenFDsInvalid = errors.New("invalid LISTEN_FDS")
+
+	ErrUpgradeDone        = errors.New("already upgraded")
+	ErrUpgradeNoFile      = errors.New("listener can't be handed over, no underlying file")
+	ErrUpgradeChildExited = errors.New("new process exited before ready")
+	ErrUpgradeNotReady    = errors.New("new process is not ready in time")
+
 	ErrGotBothInetAndUnix = errors.New("provided server is both unix and inet")
 
 	ErrLoadCACertFile = errors.New("error load trusted CA")
 
+	ErrTLSNoCertificate     = errors.New("tls certificate is not loaded")
+	ErrTLSCertNoPEM         = errors.New("tls cert-file has no PEM certificate")
+	ErrTLSKeyNoPEM          = errors.New("tls key-file has no PEM private key")
+	ErrTLSKeyUnsupported    = errors.New("tls private key type is not supported")
+	ErrTLSCertKeyMismatch   = errors.New("tls private key doesn't match certificate")
+	ErrTLSCertExpired       = errors.New("tls certificate is expired")
+	ErrTLSCertNotYetValid   = errors.New("tls certificate is not yet valid")
+	ErrTLSServerNameInvalid = errors.New("tls certificate server name must be host name or *.<host name>")
+
 	ErrUnknownVersionTLS = errors.New("unknown version TLS")
 
+	ErrTLSPresetUnknown        = errors.New("unknown tls preset, must be modern, intermediate or old")
+	ErrTLSCipherSuiteUnknown   = errors.New("unknown tls cipher suite, IANA name expected")
+	ErrTLSCipherSuiteNoHTTP2   = errors.New("tls cipherSuites have no ECDHE AES_128_GCM_SHA256 suite required by http2")
+	ErrTLSCurveUnknown         = errors.New("unknown tls curve, must be X25519, P-256, P-384 or P-521")
+	ErrTLSNextProtoInvalid     = errors.New("tls next proto must be 1-255 bytes long")
+	ErrTLSMinVersionExceedsMax = errors.New("tls minVersion exceeds maxVersion")
+
+	ErrTLSExpiryWarningNegative = errors.New("tls expiryWarning must not be negative")
+
 	ErrUnknownClientAuthTypeTLS = errors.New("unknown client auth type TLS")
 
+	ErrClientCertNotAllowed        = errors.New("client certificate is not in allow-list")
+	ErrClientCertParse             = errors.New("error parse client certificate")
+	ErrClientAllowListNoClientCert = errors.New("client allow-list is set but client certificate is never requested")
+	ErrClientAllowListNoVerify     = errors.New("client allow-list is set but client certificate is never verified")
+	ErrClientCertNotVerified       = errors.New("client certificate is not verified")
+	ErrClientCertNotGiven          = errors.New("client certificate is not given")
+	ErrInvalidSPIFFEID             = errors.New("invalid SPIFFE ID, must be spiffe://<trust-domain>/<path>")
+
+	ErrClientCertRevoked       = errors.New("client certificate is revoked")
+	ErrRevocationStatusUnknown = errors.New("client certificate revocation status is unknown")
+	ErrRevocationPolicyInvalid = errors.New("revocation policy must be hard-fail or soft-fail")
+	ErrRevocationNoVerify      = errors.New("revocation is checked but client certificate is never verified")
+	ErrCRLFileNotExists        = errors.New("crl-file doesn't exists")
+	ErrOCSPNegativeValue       = errors.New("ocsp timeout and cacheTTL must not be negative")
+	ErrOCSPResponderURLInvalid = errors.New("ocsp responder url must be http or https")
+	ErrOCSPStapleFileNotExists = errors.New("ocsp staple file doesn't exists")
+	ErrOCSPStaplingACME        = errors.New("tls ocsp stapling is not supported with acme")
+
+	ErrHTTPNegativeTimeout          = errors.New("http timeout must not be negative")
+	ErrHTTPNegativeLimit            = errors.New("http limit must not be negative")
+	ErrHTTPReadHeaderTimeoutExceeds = errors.New("http readHeaderTimeout exceeds readTimeout")
+	ErrHTTP2ReadFrameSizeOutOfRange = errors.New("http2 maxReadFrameSize must be in [16KiB, 16MiB)")
+
+	ErrGRPCNegativeValue      = errors.New("grpc option must not be negative")
+	ErrGRPCWindowSizeTooSmall = errors.New("grpc window size must be at least 64KiB")
+
+	ErrHealthPathInvalid = errors.New("health path must start with '/'")
+
+	ErrProxyProtocolModeInvalid     = errors.New("proxy protocol mode must be required or optional")
+	ErrProxyProtocolCIDRInvalid     = errors.New("proxy protocol trusted CIDR is invalid")
+	ErrProxyProtocolNoTrustedCIDRs  = errors.New("proxy protocol trustedCIDRs are not provided")
+	ErrProxyProtocolNegativeTimeout = errors.New("proxy protocol readHeaderTimeout must not be negative")
+
+	ErrACMENoDomains                    = errors.New("tls acme domains are not provided")
+	ErrACMENoCacheDir                   = errors.New("tls acme cacheDir is not provided")
+	ErrACMEDirectoryCACertFileNotExists = errors.New("tls acme directoryCACertFile doesn't exists")
+	ErrACMETLSDisabled                  = errors.New("tls acme is enabled but server tls not")
+	ErrACMEWithCertFile                 = errors.New("tls acme and certFile/keyFile/certificates are mutually exclusive")
+	ErrACMEHTTP01NotPlainHTTP           = errors.New("acmeHTTP01 is enabled on non plain http server")
+
+	ErrServeNoHTTPHandler = errors.New("http handler factory is required to serve http listeners")
+	ErrServeNoGRPCServer  = errors.New("grpc server factory is required to serve grpc listeners")
+
+	ErrMetricsPathInvalid = errors.New("metrics path must start with '/'")
+	ErrMetricsNoHTTP      = errors.New("metrics are enabled on non-http server")
+
+	ErrPprofPrefixInvalid = errors.New("pprof prefix must start with '/'")
+	ErrPprofNoHTTP        = errors.New("pprof is enabled on non-http server")
+
+	ErrServerNameInvalid   = errors.New("server name must be letters, digits, '-' and '_', starting with letter")
+	ErrServerNameDuplicate = errors.New("server name is not unique")
+
+	ErrOverlayFieldUnknown       = errors.New("unknown overlay field")
+	ErrOverlayFieldNotApplicable = errors.New("overlay field is not applicable to server kind")
+	ErrOverlayPortInvalid        = errors.New("overlay port is not a valid port number")
+	ErrOverlayEnvUnknown         = errors.New("environment variables match no server or field")
+	ErrOverlayNoServer           = errors.New("no server of kind to overlay")
+
+	ErrInterpolateUnresolved = errors.New("unresolved interpolation reference")
+
 	ErrInvalidTLSConfigSet = errors.New("client auth tls is enabled but server tls not, server tls must be enable for client tls auth can work.")
 )
+
+// joinedErrors is errors.Join of go1.20,
+// errors.Is and errors.As walk all of errs.
+// Before go1.20 they don't know Unwrap() []error, so Is and As walk errs themselves.
+type joinedErrors struct{ errs []error }
+
+func (e *joinedErrors) Error() string {
+	msgs := make([]string, 0, len(e.errs))
+
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+func (e *joinedErrors) Unwrap() []error { return e.errs }
+
+func (e *joinedErrors) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *joinedErrors) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joinErrors joins non-nil errs, nested joins are flattened.
+// Returns nil if no errs are non-nil.
+func joinErrors(errs ...error) error {
+	var joined []error
+
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		if j, ok := err.(*joinedErrors); ok {
+			joined = append(joined, j.errs...)
+		} else {
+			joined = append(joined, err)
+		}
+	}
+
+	if len(joined) == 0 {
+		return nil
+	}
+
+	return &joinedErrors{errs: joined}
+}
@@ -0,0 +1,166 @@
+package servers
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-x-pkg/dumpctx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// gRPC ignores window sizes less than that.
+const grpcMinWindowSize = 64 << 10
+
+// GRPCConfig is grpc.Server options.
+// Zero values keep gRPC defaults.
+type GRPCConfig struct {
+	// Register reflection service, e.g. for grpcurl.
+	Reflection bool `json:"reflection" yaml:"reflection" bson:"reflection"`
+
+	Keepalive struct {
+		// EnforcementPolicy is what server requires from client keepalive pings.
+		EnforcementPolicy struct {
+			MinTime             time.Duration `json:"minTime" yaml:"minTime" bson:"minTime"`
+			PermitWithoutStream bool          `json:"permitWithoutStream" yaml:"permitWithoutStream" bson:"permitWithoutStream"`
+		} `json:"enforcementPolicy" yaml:"enforcementPolicy" bson:"enforcementPolicy"`
+
+		// Params is server side keepalive and connection age.
+		Params struct {
+			MaxConnectionIdle     time.Duration `json:"maxConnectionIdle" yaml:"maxConnectionIdle" bson:"maxConnectionIdle"`
+			MaxConnectionAge      time.Duration `json:"maxConnectionAge" yaml:"maxConnectionAge" bson:"maxConnectionAge"`
+			MaxConnectionAgeGrace time.Duration `json:"maxConnectionAgeGrace" yaml:"maxConnectionAgeGrace" bson:"maxConnectionAgeGrace"`
+			Time                  time.Duration `json:"time" yaml:"time" bson:"time"`
+			Timeout               time.Duration `json:"timeout" yaml:"timeout" bson:"timeout"`
+		} `json:"params" yaml:"params" bson:"params"`
+	} `json:"keepalive" yaml:"keepalive" bson:"keepalive"`
+
+	MaxRecvMsgSize        int           `json:"maxRecvMsgSize" yaml:"maxRecvMsgSize" bson:"maxRecvMsgSize"`
+	MaxSendMsgSize        int           `json:"maxSendMsgSize" yaml:"maxSendMsgSize" bson:"maxSendMsgSize"`
+	MaxConcurrentStreams  uint32        `json:"maxConcurrentStreams" yaml:"maxConcurrentStreams" bson:"maxConcurrentStreams"`
+	ConnectionTimeout     time.Duration `json:"connectionTimeout" yaml:"connectionTimeout" bson:"connectionTimeout"`
+	InitialWindowSize     int32         `json:"initialWindowSize" yaml:"initialWindowSize" bson:"initialWindowSize"`
+	InitialConnWindowSize int32         `json:"initialConnWindowSize" yaml:"initialConnWindowSize" bson:"initialConnWindowSize"`
+}
+
+func (c *GRPCConfig) validate() error {
+	ka := &c.Keepalive
+
+	for _, v := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"keepalive.enforcementPolicy.minTime", ka.EnforcementPolicy.MinTime},
+		{"keepalive.params.maxConnectionIdle", ka.Params.MaxConnectionIdle},
+		{"keepalive.params.maxConnectionAge", ka.Params.MaxConnectionAge},
+		{"keepalive.params.maxConnectionAgeGrace", ka.Params.MaxConnectionAgeGrace},
+		{"keepalive.params.time", ka.Params.Time},
+		{"keepalive.params.timeout", ka.Params.Timeout},
+		{"connectionTimeout", c.ConnectionTimeout},
+	} {
+		if v.d < 0 {
+			return fmt.Errorf("grpc %s (%s): %w", v.name, v.d, ErrGRPCNegativeValue)
+		}
+	}
+
+	if c.MaxRecvMsgSize < 0 || c.MaxSendMsgSize < 0 {
+		return fmt.Errorf("grpc (:maxRecvMsgSize %d :maxSendMsgSize %d): %w",
+			c.MaxRecvMsgSize, c.MaxSendMsgSize, ErrGRPCNegativeValue)
+	}
+
+	for _, v := range []struct {
+		name string
+		n    int32
+	}{
+		{"initialWindowSize", c.InitialWindowSize},
+		{"initialConnWindowSize", c.InitialConnWindowSize},
+	} {
+		if v.n != 0 && v.n < grpcMinWindowSize {
+			return fmt.Errorf("grpc %s (%d): %w", v.name, v.n, ErrGRPCWindowSizeTooSmall)
+		}
+	}
+
+	return nil
+}
+
+// serverOptions translates config into grpc.ServerOption,
+// only set values are translated.
+func (c *GRPCConfig) serverOptions() (opts []grpc.ServerOption) {
+	ka := &c.Keepalive
+
+	if ep := ka.EnforcementPolicy; ep.MinTime != 0 || ep.PermitWithoutStream {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             ep.MinTime,
+			PermitWithoutStream: ep.PermitWithoutStream,
+		}))
+	}
+
+	if p := ka.Params; p.MaxConnectionIdle != 0 || p.MaxConnectionAge != 0 ||
+		p.MaxConnectionAgeGrace != 0 || p.Time != 0 || p.Timeout != 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     p.MaxConnectionIdle,
+			MaxConnectionAge:      p.MaxConnectionAge,
+			MaxConnectionAgeGrace: p.MaxConnectionAgeGrace,
+			Time:                  p.Time,
+			Timeout:               p.Timeout,
+		}))
+	}
+
+	if c.MaxRecvMsgSize != 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(c.MaxRecvMsgSize))
+	}
+
+	if c.MaxSendMsgSize != 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(c.MaxSendMsgSize))
+	}
+
+	if c.MaxConcurrentStreams != 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(c.MaxConcurrentStreams))
+	}
+
+	if c.ConnectionTimeout != 0 {
+		opts = append(opts, grpc.ConnectionTimeout(c.ConnectionTimeout))
+	}
+
+	if c.InitialWindowSize != 0 {
+		opts = append(opts, grpc.InitialWindowSize(c.InitialWindowSize))
+	}
+
+	if c.InitialConnWindowSize != 0 {
+		opts = append(opts, grpc.InitialConnWindowSize(c.InitialConnWindowSize))
+	}
+
+	return opts
+}
+
+func (c *GRPCConfig) dump(ctx *dumpctx.Ctx, w io.Writer) {
+	fmt.Fprintf(w, "%sreflection: %t\n", ctx.Indent(), c.Reflection)
+
+	fmt.Fprintf(w, "%skeepalive:\n", ctx.Indent())
+	ctx.Wrap(func() {
+		ep, p := &c.Keepalive.EnforcementPolicy, &c.Keepalive.Params
+
+		fmt.Fprintf(w, "%senforcementPolicy:\n", ctx.Indent())
+		ctx.Wrap(func() {
+			fmt.Fprintf(w, "%sminTime: %s\n", ctx.Indent(), ep.MinTime)
+			fmt.Fprintf(w, "%spermitWithoutStream: %t\n", ctx.Indent(), ep.PermitWithoutStream)
+		})
+
+		fmt.Fprintf(w, "%sparams:\n", ctx.Indent())
+		ctx.Wrap(func() {
+			fmt.Fprintf(w, "%smaxConnectionIdle: %s\n", ctx.Indent(), p.MaxConnectionIdle)
+			fmt.Fprintf(w, "%smaxConnectionAge: %s\n", ctx.Indent(), p.MaxConnectionAge)
+			fmt.Fprintf(w, "%smaxConnectionAgeGrace: %s\n", ctx.Indent(), p.MaxConnectionAgeGrace)
+			fmt.Fprintf(w, "%stime: %s\n", ctx.Indent(), p.Time)
+			fmt.Fprintf(w, "%stimeout: %s\n", ctx.Indent(), p.Timeout)
+		})
+	})
+
+	fmt.Fprintf(w, "%smaxRecvMsgSize: %d\n", ctx.Indent(), c.MaxRecvMsgSize)
+	fmt.Fprintf(w, "%smaxSendMsgSize: %d\n", ctx.Indent(), c.MaxSendMsgSize)
+	fmt.Fprintf(w, "%smaxConcurrentStreams: %d\n", ctx.Indent(), c.MaxConcurrentStreams)
+	fmt.Fprintf(w, "%sconnectionTimeout: %s\n", ctx.Indent(), c.ConnectionTimeout)
+	fmt.Fprintf(w, "%sinitialWindowSize: %d\n", ctx.Indent(), c.InitialWindowSize)
+	fmt.Fprintf(w, "%sinitialConnWindowSize: %d\n", ctx.Indent(), c.InitialConnWindowSize)
+}
@@ -0,0 +1,96 @@
+package servers_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCConfigValidate(t *testing.T) {
+	tests := []struct {
+		raw string
+		err error
+	}{
+		{`- kind: [inet, grpc]
+  grpc:
+    keepalive:
+      enforcementPolicy:
+        minTime: 10s
+        permitWithoutStream: true
+      params:
+        maxConnectionAge: 1h
+        time: 30s
+    maxRecvMsgSize: 8388608
+    initialWindowSize: 1048576`, nil},
+
+		{`- kind: [inet, grpc]
+  grpc:
+    keepalive:
+      params:
+        timeout: -1s`, servers.ErrGRPCNegativeValue},
+
+		{`- kind: [inet, grpc]
+  grpc:
+    initialConnWindowSize: 1024`, servers.ErrGRPCWindowSizeTooSmall},
+	}
+
+	for i, tt := range tests {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(tt.raw, &ss); err != nil {
+			t.Fatalf("%d: unmarshal yaml: %s", i, err)
+		}
+
+		ss.Defaultize("127.0.0.1", 8000, "")
+
+		if err := ss.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("%d: expected %v, got %v", i, tt.err, err)
+		}
+	}
+}
+
+func TestGRPCConfigServerOptions(t *testing.T) {
+	ss := newTestServers(t, `- kind: [inet, grpc]
+  grpc:
+    maxSendMsgSize: 1`)
+
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeGRPC(func(_ servers.Server, opts ...grpc.ServerOption) *grpc.Server {
+			server := grpc.NewServer(opts...)
+			healthpb.RegisterHealthServer(server, health.NewServer())
+
+			return server
+		}, servers.Context(ctx))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+
+	ctxTimeout, cancelTimeout := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelTimeout()
+
+	_, err = healthpb.NewHealthClient(conn).Check(ctxTimeout, &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected maxSendMsgSize to be applied, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package servers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestServeGRPCUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "grpc.sock")
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [unix, grpc]
+  addr: %s
+  grpc:
+    reflection: true`, sock))
+
+	listeners := listenTestServers(t, ss)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeGRPC(func(_ servers.Server, opts ...grpc.ServerOption) *grpc.Server {
+			server := grpc.NewServer(opts...)
+			healthpb.RegisterHealthServer(server, health.NewServer())
+
+			return server
+		}, servers.Context(ctx))
+	}()
+
+	conn, err := grpc.Dial("unix://"+sock, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+
+	ctxTimeout, cancelTimeout := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelTimeout()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctxTimeout, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("health check over unix socket: %s", err)
+	}
+
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Errorf("serve: %s", err)
+	}
+}
+
+func TestServerUnixRejectsTLS(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		err    error
+	}{
+		{`- kind: [unix, grpc]
+  addr: /tmp/foo.sock
+  tls:
+    enable: true`, servers.ErrUnixTLSNotSupported},
+
+		{`- kind: [unix, grpc]
+  addr: /tmp/foo.sock
+  clientAuth:
+    tls:
+      enable: true`, servers.ErrUnixClientAuthNotSupported},
+
+		// disabled inet only options are shared configs, not errors
+		{`- kind: [unix, grpc]
+  addr: /tmp/foo.sock
+  tls:
+    enable: false
+    certFile: /etc/tls/tls.crt
+  clientAuth:
+    tls:
+      enable: false`, nil},
+	} {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(tt.config, &ss); err != nil {
+			t.Fatalf("%q: unmarshal yaml: %s", tt.config, err)
+		}
+
+		ss.Defaultize("", 0, "")
+
+		if err := ss.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("%q: expected %v, got %v", tt.config, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package servers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Health is serving status of services shared by
+// gRPC health service (grpc.health.v1) and HTTP readiness endpoint.
+//
+// Empty service name is overall status of server.
+type Health struct {
+	grpc *health.Server
+
+	mu       sync.RWMutex
+	statuses map[string]bool
+	shutdown bool
+}
+
+// NewHealth returns Health with overall status set to serving.
+func NewHealth() *Health {
+	return &Health{
+		grpc:     health.NewServer(),
+		statuses: map[string]bool{"": true},
+	}
+}
+
+func toServingStatus(serving bool) healthpb.HealthCheckResponse_ServingStatus {
+	if serving {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// SetServing sets status of service. Ignored after Shutdown until Resume.
+func (h *Health) SetServing(service string, serving bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shutdown {
+		return
+	}
+
+	h.statuses[service] = serving
+	h.grpc.SetServingStatus(service, toServingStatus(serving))
+}
+
+// IsServing reports status of service, unknown services are not serving.
+func (h *Health) IsServing(service string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return !h.shutdown && h.statuses[service]
+}
+
+// Shutdown sets all services not serving.
+// It's called once serving context is done, before servers are stopped.
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.shutdown = true
+	h.grpc.Shutdown()
+}
+
+// Resume restores statuses set before Shutdown.
+func (h *Health) Resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.shutdown = false
+	h.grpc.Resume()
+
+	for service, serving := range h.statuses {
+		h.grpc.SetServingStatus(service, toServingStatus(serving))
+	}
+}
+
+// GRPC returns grpc.health.v1 implementation.
+func (h *Health) GRPC() healthpb.HealthServer { return h.grpc }
+
+// HealthConfig mounts liveness and readiness endpoints on HTTP
+// and registers grpc.health.v1 on gRPC servers.
+type HealthConfig struct {
+	Enable bool `json:"enable" yaml:"enable" bson:"enable"`
+	// LivenessPath responds 200 while process is able to serve requests at all.
+	LivenessPath string `json:"livenessPath" yaml:"livenessPath" bson:"livenessPath"`
+	// ReadinessPath responds 200 if service (?service=, overall if empty) is serving, 503 otherwise.
+	ReadinessPath string `json:"readinessPath" yaml:"readinessPath" bson:"readinessPath"`
+}
+
+func (c *HealthConfig) defaultize() {
+	if c.LivenessPath == "" {
+		c.LivenessPath = defaultHealthLivenessPath
+	}
+
+	if c.ReadinessPath == "" {
+		c.ReadinessPath = defaultHealthReadinessPath
+	}
+}
+
+func (c *HealthConfig) interpolate(interpolateFn func(string) string) {
+	c.LivenessPath = interpolateFn(c.LivenessPath)
+	c.ReadinessPath = interpolateFn(c.ReadinessPath)
+}
+
+func (c *HealthConfig) validate() error {
+	if !c.Enable {
+		return nil
+	}
+
+	for _, v := range []string{c.LivenessPath, c.ReadinessPath} {
+		if !strings.HasPrefix(v, "/") {
+			return ErrHealthPathInvalid
+		}
+	}
+
+	return nil
+}
+
+func withHealth(c *HealthConfig, h *Health, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case c.LivenessPath:
+			io.WriteString(w, "ok\n")
+		case c.ReadinessPath:
+			if !h.IsServing(r.URL.Query().Get("service")) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				io.WriteString(w, "not serving\n")
+
+				return
+			}
+
+			io.WriteString(w, "ok\n")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package servers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealth(t *testing.T) {
+	ss := newTestServers(t, `- kind: [inet, http, grpc]
+  health:
+    enable: true
+    readinessPath: /ready`)
+
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	h := servers.NewHealth()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeMux(
+			serveOneBody("app"),
+			func(_ servers.Server, opts ...grpc.ServerOption) *grpc.Server { return grpc.NewServer(opts...) },
+			servers.Context(ctx),
+			servers.WithHealth(h),
+			servers.HealthShutdownDelay(500*time.Millisecond),
+		)
+	}()
+
+	httpStatus := func(path string) int {
+		t.Helper()
+
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %s", path, err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+
+	grpcStatus := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+
+		ctxTimeout, cancelTimeout := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelTimeout()
+
+		resp, err := healthpb.NewHealthClient(conn).Check(ctxTimeout, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("grpc health check: %s", err)
+		}
+
+		return resp.Status
+	}
+
+	if code := httpStatus("/healthz"); code != http.StatusOK {
+		t.Errorf("liveness: %d", code)
+	}
+
+	if code := httpStatus("/ready"); code != http.StatusOK {
+		t.Errorf("readiness: %d", code)
+	}
+
+	h.SetServing("billing", false)
+
+	if code := httpStatus("/ready?service=billing"); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness of not serving service: %d", code)
+	}
+
+	if st := grpcStatus("billing"); st != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("grpc status of not serving service: %s", st)
+	}
+
+	cancel()
+
+	// during shutdown delay servers are up, but not ready
+	time.Sleep(100 * time.Millisecond)
+
+	if code := httpStatus("/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness on shutdown: %d", code)
+	}
+
+	if code := httpStatus("/healthz"); code != http.StatusOK {
+		t.Errorf("liveness on shutdown: %d", code)
+	}
+
+	if st := grpcStatus(""); st != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("grpc status on shutdown: %s", st)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("serve: %s", err)
+	}
+}
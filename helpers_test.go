@@ -0,0 +1,218 @@
+package servers_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"gopkg.in/yaml.v2"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+
+	certFile string
+	keyFile  string
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		t.Fatalf("load x509 key pair: %s", err)
+	}
+
+	return cert
+}
+
+type testCertOpts struct {
+	cn       string
+	dns      []string
+	ips      []net.IP
+	uris     []string
+	isCA     bool
+	isClient bool
+	rsa      bool
+
+	ocspServer string
+
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+var testSerial int64
+
+func newTestCert(t *testing.T, dir, name string, parent *testCert, o testCertOpts) *testCert {
+	t.Helper()
+
+	var (
+		key crypto.Signer
+		err error
+	)
+
+	if o.rsa {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	if o.notBefore.IsZero() {
+		o.notBefore = time.Now().Add(-time.Hour)
+	}
+
+	if o.notAfter.IsZero() {
+		o.notAfter = time.Now().Add(24 * time.Hour)
+	}
+
+	testSerial++
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: o.cn},
+		DNSNames:     o.dns,
+		IPAddresses:  o.ips,
+		NotBefore:    o.notBefore,
+		NotAfter:     o.notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	if o.ocspServer != "" {
+		tmpl.OCSPServer = []string{o.ocspServer}
+	}
+
+	for _, raw := range o.uris {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("parse uri: %s", err)
+		}
+
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+
+	switch {
+	case o.isCA:
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	case o.isClient:
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	if o.rsa {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, key.Public(), signerKey)
+	if err != nil {
+		t.Fatalf("create certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %s", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %s", err)
+	}
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+
+	writeTestFile(t, c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeTestFile(t, c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+
+	return c
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %s", path, err)
+	}
+}
+
+func newTestServers(t *testing.T, raw string) servers.Servers {
+	t.Helper()
+
+	var ss servers.Servers
+
+	if err := yaml.Unmarshal([]byte(raw), &ss); err != nil {
+		t.Fatalf("unmarshal yaml: %s", err)
+	}
+
+	if err := ss.Defaultize("127.0.0.1", 0, ""); err != nil {
+		t.Fatalf("defaultize: %s", err)
+	}
+
+	if err := ss.Validate(); err != nil {
+		t.Fatalf("validate: %s", err)
+	}
+
+	return ss
+}
+
+func listenTestServers(t *testing.T, ss servers.Servers, fnArgs ...servers.Arg) servers.Servers {
+	t.Helper()
+
+	listeners, errs := ss.Listen(fnArgs...)
+	if len(errs) != 0 {
+		listeners.Close()
+		t.Fatalf("listen: %s", errs)
+	}
+
+	t.Cleanup(func() { listeners.Close() })
+
+	return listeners
+}
+
+// listenerAddr returns actual bound address of listener created for server s.
+// Listen doesn't preserve config order.
+func listenerAddr(t *testing.T, listeners servers.Servers, s servers.Server) string {
+	t.Helper()
+
+	for _, l := range listeners {
+		if sl, ok := l.Server.(*servers.ServerListener); ok && sl.Server == s {
+			return sl.Listener.Addr().String()
+		}
+	}
+
+	t.Fatalf("no listener for %s", s.Addr())
+
+	return ""
+}
+
+func yamlUnmarshal(raw string, v interface{}) error { return yaml.Unmarshal([]byte(raw), v) }
@@ -0,0 +1,141 @@
+package servers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-x-pkg/dumpctx"
+	"golang.org/x/net/http2"
+)
+
+const (
+	http2MinReadFrameSize = 16 << 10
+	http2MaxReadFrameSize = 1<<24 - 1
+)
+
+// HTTPConfig is http.Server timeouts and limits.
+// Zero timeout means no timeout (as in net/http),
+// except readHeaderTimeout which zero is defaulted.
+type HTTPConfig struct {
+	// see: Potential slowloris attack GO-S2112
+	ReadHeaderTimeout time.Duration `json:"readHeaderTimeout" yaml:"readHeaderTimeout" bson:"readHeaderTimeout"`
+	// ReadTimeout covers reading of whole request, body included.
+	ReadTimeout time.Duration `json:"readTimeout" yaml:"readTimeout" bson:"readTimeout"`
+	// WriteTimeout covers writing of response. Keep zero for streaming responses.
+	WriteTimeout time.Duration `json:"writeTimeout" yaml:"writeTimeout" bson:"writeTimeout"`
+	// IdleTimeout is keep-alive timeout. Defaults to 2 minutes,
+	// 0 disables it (readTimeout is used then, as in net/http).
+	IdleTimeout    *time.Duration `json:"idleTimeout" yaml:"idleTimeout" bson:"idleTimeout"`
+	MaxHeaderBytes int            `json:"maxHeaderBytes" yaml:"maxHeaderBytes" bson:"maxHeaderBytes"`
+
+	// HTTP2 is applied to TLS (h2) and h2c connections.
+	HTTP2 struct {
+		MaxConcurrentStreams         uint32 `json:"maxConcurrentStreams" yaml:"maxConcurrentStreams" bson:"maxConcurrentStreams"`
+		MaxReadFrameSize             uint32 `json:"maxReadFrameSize" yaml:"maxReadFrameSize" bson:"maxReadFrameSize"`
+		MaxUploadBufferPerConnection int32  `json:"maxUploadBufferPerConnection" yaml:"maxUploadBufferPerConnection" bson:"maxUploadBufferPerConnection"`
+		MaxUploadBufferPerStream     int32  `json:"maxUploadBufferPerStream" yaml:"maxUploadBufferPerStream" bson:"maxUploadBufferPerStream"`
+	} `json:"http2" yaml:"http2" bson:"http2"`
+}
+
+func (c *HTTPConfig) idleTimeout() time.Duration {
+	if c.IdleTimeout == nil {
+		return defaultHTTPIdleTimeout
+	}
+
+	return *c.IdleTimeout
+}
+
+func (c *HTTPConfig) defaultize() {
+	if c.ReadHeaderTimeout == 0 {
+		c.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+
+	if c.MaxHeaderBytes == 0 {
+		c.MaxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+
+	if c.HTTP2.MaxConcurrentStreams == 0 {
+		c.HTTP2.MaxConcurrentStreams = defaultHTTP2MaxConcurrentStreams
+	}
+}
+
+func (c *HTTPConfig) validate() error {
+	for _, v := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"readHeaderTimeout", c.ReadHeaderTimeout},
+		{"readTimeout", c.ReadTimeout},
+		{"writeTimeout", c.WriteTimeout},
+		{"idleTimeout", c.idleTimeout()},
+	} {
+		if v.d < 0 {
+			return fmt.Errorf("http %s (%s): %w", v.name, v.d, ErrHTTPNegativeTimeout)
+		}
+	}
+
+	if c.ReadTimeout != 0 && c.ReadHeaderTimeout > c.ReadTimeout {
+		return fmt.Errorf("http (:readHeaderTimeout %s :readTimeout %s): %w",
+			c.ReadHeaderTimeout, c.ReadTimeout, ErrHTTPReadHeaderTimeoutExceeds)
+	}
+
+	if c.MaxHeaderBytes < 0 {
+		return fmt.Errorf("http maxHeaderBytes (%d): %w", c.MaxHeaderBytes, ErrHTTPNegativeLimit)
+	}
+
+	if v := c.HTTP2.MaxReadFrameSize; v != 0 && (v < http2MinReadFrameSize || v > http2MaxReadFrameSize) {
+		return fmt.Errorf("http2 maxReadFrameSize (%d): %w", v, ErrHTTP2ReadFrameSizeOutOfRange)
+	}
+
+	if c.HTTP2.MaxUploadBufferPerConnection < 0 || c.HTTP2.MaxUploadBufferPerStream < 0 {
+		return fmt.Errorf("http2 maxUploadBuffer: %w", ErrHTTPNegativeLimit)
+	}
+
+	return nil
+}
+
+func (c *HTTPConfig) apply(server *http.Server) {
+	server.ReadHeaderTimeout = c.ReadHeaderTimeout
+	server.ReadTimeout = c.ReadTimeout
+	server.WriteTimeout = c.WriteTimeout
+	server.IdleTimeout = c.idleTimeout()
+	server.MaxHeaderBytes = c.MaxHeaderBytes
+}
+
+// configureHTTP2 enables h2 on server (ALPN for TLS)
+// and returns http2.Server to be used for h2c as well.
+// Must be called after server.TLSConfig is set.
+func (c *HTTPConfig) configureHTTP2(server *http.Server) (*http2.Server, error) {
+	h2s := &http2.Server{
+		MaxConcurrentStreams:         c.HTTP2.MaxConcurrentStreams,
+		MaxReadFrameSize:             c.HTTP2.MaxReadFrameSize,
+		MaxUploadBufferPerConnection: c.HTTP2.MaxUploadBufferPerConnection,
+		MaxUploadBufferPerStream:     c.HTTP2.MaxUploadBufferPerStream,
+		IdleTimeout:                  c.idleTimeout(),
+	}
+
+	// registers graceful GOAWAY on Shutdown and adds h2 to TLS NextProtos
+	if err := http2.ConfigureServer(server, h2s); err != nil {
+		return nil, fmt.Errorf("configure http2 (%s) failed: %w", server.Addr, err)
+	}
+
+	return h2s, nil
+}
+
+func (c *HTTPConfig) dump(ctx *dumpctx.Ctx, w io.Writer) {
+	fmt.Fprintf(w, "%sreadHeaderTimeout: %s\n", ctx.Indent(), c.ReadHeaderTimeout)
+	fmt.Fprintf(w, "%sreadTimeout: %s\n", ctx.Indent(), c.ReadTimeout)
+	fmt.Fprintf(w, "%swriteTimeout: %s\n", ctx.Indent(), c.WriteTimeout)
+	fmt.Fprintf(w, "%sidleTimeout: %s\n", ctx.Indent(), c.idleTimeout())
+	fmt.Fprintf(w, "%smaxHeaderBytes: %d\n", ctx.Indent(), c.MaxHeaderBytes)
+
+	fmt.Fprintf(w, "%shttp2:\n", ctx.Indent())
+	ctx.Wrap(func() {
+		fmt.Fprintf(w, "%smaxConcurrentStreams: %d\n", ctx.Indent(), c.HTTP2.MaxConcurrentStreams)
+		fmt.Fprintf(w, "%smaxReadFrameSize: %d\n", ctx.Indent(), c.HTTP2.MaxReadFrameSize)
+		fmt.Fprintf(w, "%smaxUploadBufferPerConnection: %d\n", ctx.Indent(), c.HTTP2.MaxUploadBufferPerConnection)
+		fmt.Fprintf(w, "%smaxUploadBufferPerStream: %d\n", ctx.Indent(), c.HTTP2.MaxUploadBufferPerStream)
+	})
+}
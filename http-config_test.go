@@ -0,0 +1,110 @@
+package servers_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/servers"
+)
+
+func TestHTTPConfig(t *testing.T) {
+	tests := []struct {
+		raw string
+		err error
+	}{
+		{`- kind: inet
+  http:
+    readTimeout: 30s
+    writeTimeout: 1m
+    idleTimeout: 5m
+    maxHeaderBytes: 65536
+    http2:
+      maxConcurrentStreams: 100
+      maxReadFrameSize: 1048576`, nil},
+
+		{`- kind: inet
+  http:
+    writeTimeout: -1s`, servers.ErrHTTPNegativeTimeout},
+
+		{`- kind: inet
+  http:
+    readHeaderTimeout: 10s
+    readTimeout: 5s`, servers.ErrHTTPReadHeaderTimeoutExceeds},
+
+		{`- kind: inet
+  http:
+    maxHeaderBytes: -1`, servers.ErrHTTPNegativeLimit},
+
+		{`- kind: inet
+  http:
+    http2:
+      maxReadFrameSize: 1024`, servers.ErrHTTP2ReadFrameSizeOutOfRange},
+	}
+
+	for i, tt := range tests {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(tt.raw, &ss); err != nil {
+			t.Fatalf("%d: unmarshal yaml: %s", i, err)
+		}
+
+		ss.Defaultize("127.0.0.1", 8000, "")
+
+		if err := ss.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("%d: expected %v, got %v", i, tt.err, err)
+		}
+
+		if tt.err != nil {
+			continue
+		}
+
+		w := bytes.Buffer{}
+		dctx := dumpctx.Ctx{}
+		dctx.Init()
+
+		ss.Dump(&dctx, &w)
+
+		for _, v := range []string{"readTimeout: 30s", "writeTimeout: 1m0s", "idleTimeout: 5m0s",
+			"maxHeaderBytes: 65536", "maxConcurrentStreams: 100", "maxReadFrameSize: 1048576"} {
+			if !strings.Contains(w.String(), v) {
+				t.Errorf("%d: dump has no %q:\n%s", i, v, w.String())
+			}
+		}
+	}
+}
+
+func TestHTTPConfigIdleTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		http     string
+		expected string
+	}{
+		{"", "idleTimeout: 2m0s"},
+		{"http: {idleTimeout: 5m}", "idleTimeout: 5m0s"},
+		{"http: {idleTimeout: 0s}", "idleTimeout: 0s"},
+	} {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal("- kind: inet\n  "+tt.http, &ss); err != nil {
+			t.Fatalf("%q: unmarshal yaml: %s", tt.http, err)
+		}
+
+		ss.Defaultize("127.0.0.1", 8000, "")
+
+		if err := ss.Validate(); err != nil {
+			t.Fatalf("%q: %s", tt.http, err)
+		}
+
+		w := bytes.Buffer{}
+		dctx := dumpctx.Ctx{}
+		dctx.Init()
+
+		ss.Dump(&dctx, &w)
+
+		if !strings.Contains(w.String(), tt.expected) {
+			t.Errorf("%q: dump has no %q:\n%s", tt.http, tt.expected, w.String())
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package servers
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const interpolateFilePrefix = "file:"
+
+var (
+	interpolateRefRe = regexp.MustCompile(`\$\{[^}]*\}`)
+	envNameRe        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ExpandEnv is interpolation function for Interpolate:
+//
+//	${VAR}          value of environment variable
+//	${VAR:-default} default if variable is unset or empty
+//	${file:/path}   content of file, trailing newlines trimmed (secrets)
+//
+// Nested references (${VAR:-${OTHER}}) are not supported.
+// Unresolved references (unset variable without default, unreadable file,
+// nested reference) are kept as is and reported by Validate.
+func ExpandEnv(v string) string {
+	return interpolateRefRe.ReplaceAllStringFunc(v, func(ref string) string {
+		if resolved, ok := resolveRef(ref[2 : len(ref)-1]); ok {
+			return resolved
+		}
+
+		return ref
+	})
+}
+
+func resolveRef(expr string) (string, bool) {
+	// reference is cut at first "}", never resolve part of nested one
+	if strings.Contains(expr, "${") {
+		return "", false
+	}
+
+	if path := strings.TrimPrefix(expr, interpolateFilePrefix); path != expr {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return "", false
+		}
+
+		return strings.TrimRight(string(raw), "\r\n"), true
+	}
+
+	name, def, hasDefault := expr, "", false
+	if i := strings.Index(expr, ":-"); i >= 0 {
+		name, def, hasDefault = expr[:i], expr[i+2:], true
+	}
+
+	if !envNameRe.MatchString(name) {
+		return "", false
+	}
+
+	v, ok := os.LookupEnv(name)
+	if hasDefault && v == "" {
+		return def, true
+	}
+
+	return v, ok
+}
+
+func interpolateStrings(interpolateFn func(string) string, vs []string) {
+	for i := range vs {
+		vs[i] = interpolateFn(vs[i])
+	}
+}
+
+// validateInterpolated reports references left in string fields of s.
+func validateInterpolated(s serverInterpolator) error {
+	var refs []string
+
+	s.interpolate(func(v string) string {
+		refs = append(refs, interpolateRefRe.FindAllString(v, -1)...)
+
+		return v
+	})
+
+	if len(refs) != 0 {
+		return fmt.Errorf("(:refs %s): %w", strings.Join(refs, " "), ErrInterpolateUnresolved)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package servers_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-x-pkg/servers"
+)
+
+func TestExpandEnv(t *testing.T) {
+	dir := t.TempDir()
+
+	secret := filepath.Join(dir, "secret")
+	writeTestFile(t, secret, []byte("s3cret\n"))
+
+	t.Setenv("SERVERS_TEST_SET", "value")
+	t.Setenv("SERVERS_TEST_EMPTY", "")
+
+	for _, tt := range []struct {
+		in       string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"${SERVERS_TEST_SET}", "value"},
+		{"${SERVERS_TEST_EMPTY}", ""},
+		{"${SERVERS_TEST_SET:-default}", "value"},
+		{"${SERVERS_TEST_EMPTY:-default}", "default"},
+		{"${SERVERS_TEST_UNSET:-default}", "default"},
+		{"${SERVERS_TEST_UNSET:-}", ""},
+		{"${SERVERS_TEST_UNSET}", "${SERVERS_TEST_UNSET}"},
+		{"${file:" + secret + "}", "s3cret"},
+		{"${file:" + filepath.Join(dir, "missing") + "}", "${file:" + filepath.Join(dir, "missing") + "}"},
+		{"${not a name}", "${not a name}"},
+		{"$SERVERS_TEST_SET", "$SERVERS_TEST_SET"},
+		{"${SERVERS_TEST_SET}/${SERVERS_TEST_UNSET:-app}.sock", "value/app.sock"},
+		{"${SERVERS_TEST_UNSET:-${SERVERS_TEST_SET}}", "${SERVERS_TEST_UNSET:-${SERVERS_TEST_SET}}"},
+		{"${file:${SERVERS_TEST_SET}}", "${file:${SERVERS_TEST_SET}}"},
+	} {
+		if got := servers.ExpandEnv(tt.in); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.expected, got)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server"})
+
+	clientCN := filepath.Join(dir, "client-cn")
+	writeTestFile(t, clientCN, []byte("client\n"))
+
+	t.Setenv("SERVERS_TEST_RUNTIME_DIR", dir)
+	t.Setenv("SERVERS_TEST_TLS_DIR", dir)
+
+	var ss servers.Servers
+
+	if err := yamlUnmarshal(fmt.Sprintf(`- kind: [unix, http]
+  addr: ${SERVERS_TEST_RUNTIME_DIR}/app.sock
+  pprof:
+    enable: true
+    prefix: ${SERVERS_TEST_PPROF_PREFIX:-/debug/pprof}
+- kind: [inet, http]
+  host: ${SERVERS_TEST_HOST:-127.0.0.1}
+  network: ${SERVERS_TEST_NETWORK:-tcp4}
+  tls:
+    enable: true
+    certFile: ${SERVERS_TEST_TLS_DIR}/%s
+    keyFile: ${SERVERS_TEST_TLS_DIR}/%s
+  clientAuth:
+    tls:
+      enable: true
+      authType: require-and-verify-client-cert
+      caCertFile: ${SERVERS_TEST_TLS_DIR}/%s
+      clientCommonNames: ["${file:%s}"]`,
+		filepath.Base(srv.certFile), filepath.Base(srv.keyFile), filepath.Base(ca.certFile), clientCN,
+	), &ss); err != nil {
+		t.Fatal(err)
+	}
+
+	ss.Interpolate(servers.ExpandEnv)
+	ss.Defaultize("127.0.0.1", 0, "")
+
+	if err := ss.Validate(); err != nil {
+		t.Fatalf("validate: %s", err)
+	}
+
+	unix := ss[0].Server.(*servers.ServerUNIX)
+	inet := ss[1].Server.(*servers.ServerINET)
+
+	for _, tt := range []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{"unix addr", unix.Address, filepath.Join(dir, "app.sock")},
+		{"pprof prefix", unix.Pprof.Prefix, "/debug/pprof"},
+		{"host", inet.Host, "127.0.0.1"},
+		{"network", inet.Network(), "tcp4"},
+		{"cert-file", inet.TLS.CertFile, srv.certFile},
+		{"ca-cert-file", inet.ClientAuth.TLS.CACertFile, ca.certFile},
+		{"client common name", strings.Join(inet.ClientAuth.TLS.ClientCommonNames, ","), "client"},
+	} {
+		if tt.got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, tt.got)
+		}
+	}
+}
+
+func TestInterpolateUnresolved(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		ref    string
+	}{
+		{"- kind: [unix, http]\n  addr: ${SERVERS_TEST_UNSET}/app.sock", "${SERVERS_TEST_UNSET}"},
+		{"- kind: [inet, http]\n  host: ${SERVERS_TEST_UNSET}", "${SERVERS_TEST_UNSET}"},
+		{"- kind: [inet, http]\n  host: ${SERVERS_TEST_UNSET:-${HOST}}", "${SERVERS_TEST_UNSET:-${HOST}"},
+		{"- kind: [inet, http]\n  host: 127.0.0.1\n  proxyProtocol:\n    trustedCIDRs: [\"${file:/nonexistent}\"]", "${file:/nonexistent}"},
+	} {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(tt.config, &ss); err != nil {
+			t.Fatal(err)
+		}
+
+		ss.Interpolate(servers.ExpandEnv)
+		ss.Defaultize("127.0.0.1", 0, "")
+
+		err := ss.Validate()
+		if !errors.Is(err, servers.ErrInterpolateUnresolved) {
+			t.Errorf("%q: expected %v, got %v", tt.config, servers.ErrInterpolateUnresolved, err)
+		} else if !strings.Contains(err.Error(), tt.ref) {
+			t.Errorf("%q: error doesn't name %s: %s", tt.config, tt.ref, err)
+		}
+	}
+}
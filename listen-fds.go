@@ -0,0 +1,206 @@
+package servers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// systemd socket activation protocol, see sd_listen_fds(3).
+const (
+	listenFDsStart = 3
+
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+
+	// LISTEN_PID can't be known by parent before fork,
+	// so on upgrade parent pid is passed and checked instead.
+	envListenPPID = "SERVERS_LISTEN_PPID"
+	// Socket of unix listener is bound under temporary path and renamed,
+	// so its configured path is passed on upgrade (JSON list, "" for others).
+	envListenAddrs = "SERVERS_LISTEN_ADDRS"
+)
+
+type inheritedListener struct {
+	name     string
+	listener net.Listener
+	taken    bool
+}
+
+// listenFDs is set of pre-opened listening sockets
+// passed by systemd (or parent process).
+type listenFDs struct {
+	mu        sync.Mutex
+	listeners []*inheritedListener
+}
+
+// newListenFDsFromEnv collects sockets passed through LISTEN_FDS.
+// Environment is unset, so it's not inherited by children.
+// Returns nil if nothing passed or LISTEN_PID (SERVERS_LISTEN_PPID) is not ours.
+func newListenFDsFromEnv() (*listenFDs, error) {
+	defer func() {
+		os.Unsetenv(envListenPID)
+		os.Unsetenv(envListenPPID)
+		os.Unsetenv(envListenFDs)
+		os.Unsetenv(envListenFDNames)
+		os.Unsetenv(envListenAddrs)
+	}()
+
+	rawFDs := os.Getenv(envListenFDs)
+	if rawFDs == "" {
+		return nil, nil
+	}
+
+	switch rawPID, rawPPID := os.Getenv(envListenPID), os.Getenv(envListenPPID); {
+	case rawPID != "":
+		if pid, err := strconv.Atoi(rawPID); err != nil {
+			return nil, fmt.Errorf("%s=%q: %w", envListenPID, rawPID, err)
+		} else if pid != os.Getpid() {
+			return nil, nil
+		}
+	case rawPPID != "":
+		if ppid, err := strconv.Atoi(rawPPID); err != nil {
+			return nil, fmt.Errorf("%s=%q: %w", envListenPPID, rawPPID, err)
+		} else if ppid != os.Getppid() {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(rawFDs)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%s=%q: %w", envListenFDs, rawFDs, ErrListenFDsInvalid)
+	}
+
+	var names []string
+	if v := os.Getenv(envListenFDNames); v != "" {
+		names = strings.Split(v, ":")
+	}
+
+	var addrs []string
+	if v := os.Getenv(envListenAddrs); v != "" {
+		if err := json.Unmarshal([]byte(v), &addrs); err != nil {
+			return nil, fmt.Errorf("%s=%q: %w", envListenAddrs, v, err)
+		}
+	}
+
+	return newListenFDs(n, names, addrs)
+}
+
+func newListenFDs(n int, names, addrs []string) (*listenFDs, error) {
+	fds := &listenFDs{}
+
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+
+		listener, err := net.FileListener(f)
+
+		// FileListener dups fd (close-on-exec)
+		f.Close()
+
+		if err != nil {
+			fds.close()
+
+			return nil, fmt.Errorf("inherited fd %d (:name %q): %w", fd, name, err)
+		}
+
+		// unix socket handed over by upgrade is ours to unlink
+		if ul, ok := listener.(*net.UnixListener); ok && i < len(addrs) && addrs[i] != "" {
+			listener = &unixListener{UnixListener: ul, path: addrs[i], unlink: true}
+		}
+
+		fds.listeners = append(fds.listeners, &inheritedListener{name: name, listener: listener})
+	}
+
+	return fds, nil
+}
+
+// take returns inherited listener for server (matched by fd name or by address)
+// or nil if there is no such.
+// Each listener is taken at most once.
+func (fds *listenFDs) take(s Server) net.Listener {
+	fds.mu.Lock()
+	defer fds.mu.Unlock()
+
+	name := s.Base().FDName
+
+	for _, il := range fds.listeners {
+		if il.taken {
+			continue
+		}
+
+		if (name != "" && il.name == name) || (name == "" && isListenerAddr(s, il.listener.Addr())) {
+			il.taken = true
+
+			return il.listener
+		}
+	}
+
+	return nil
+}
+
+// closeUntaken closes listeners not matched by any server
+// (otherwise connections are queued but never accepted)
+// and returns their descriptions.
+func (fds *listenFDs) closeUntaken() (ss []string) {
+	fds.mu.Lock()
+	defer fds.mu.Unlock()
+
+	for _, il := range fds.listeners {
+		if !il.taken {
+			ss = append(ss, fmt.Sprintf("%s (%s)", il.name, il.listener.Addr()))
+
+			il.listener.Close()
+		}
+	}
+
+	return ss
+}
+
+func (fds *listenFDs) close() {
+	for _, il := range fds.listeners {
+		il.listener.Close()
+	}
+}
+
+func isListenerAddr(s Server, addr net.Addr) bool {
+	if s.Kind().Has(KindUNIX) {
+		a, ok := addr.(*net.UnixAddr)
+
+		return ok && a.Name == s.Addr()
+	}
+
+	a, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	want, err := net.ResolveTCPAddr(s.Network(), s.Addr())
+	if err != nil {
+		return false
+	}
+
+	if want.Port != a.Port {
+		return false
+	}
+
+	// "0.0.0.0" and "::" are the same wildcard socket
+	if len(want.IP) == 0 || want.IP.IsUnspecified() {
+		return len(a.IP) == 0 || a.IP.IsUnspecified()
+	}
+
+	return want.IP.Equal(a.IP)
+}
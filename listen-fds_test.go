@@ -0,0 +1,127 @@
+package servers_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+)
+
+const envTestSocketActivationChild = "SERVERS_TEST_SOCKET_ACTIVATION_CHILD"
+
+// TestSocketActivationChild is run in subprocess by TestSocketActivation
+// with listening sockets at fd 3 and 4, like systemd does.
+// Second one matches no server.
+func TestSocketActivationChild(t *testing.T) {
+	if os.Getenv(envTestSocketActivationChild) == "" {
+		t.Skip("subprocess only")
+	}
+
+	// LISTEN_PID is known only after fork
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	ss := newTestServers(t, `- kind: [inet, http]
+  port: 1
+  fdName: web`)
+
+	listeners := listenTestServers(t, ss, servers.SocketActivation(true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := listeners.ServeHTTP(func(servers.Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "activated")
+
+			if r.URL.Path == "/stop" {
+				cancel()
+			}
+		})
+	}, servers.Context(ctx))
+	if err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("LISTEN_FDS must be unset")
+	}
+}
+
+func TestSocketActivation(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stray, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strayFile, err := stray.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSocketActivationChild$")
+	cmd.Env = append(os.Environ(),
+		envTestSocketActivationChild+"=1",
+		"LISTEN_FDS=2",
+		"LISTEN_FDNAMES=web:stray",
+	)
+	cmd.ExtraFiles = []*os.File{f, strayFile}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// child holds the only descriptor of stray socket
+	strayFile.Close()
+	stray.Close()
+
+	client := http.Client{Timeout: 10 * time.Second}
+
+	for _, path := range []string{"/", "/stop"} {
+		resp, err := client.Get("http://" + ln.Addr().String() + path)
+		if err != nil {
+			cmd.Process.Kill()
+			t.Fatalf("GET %s: %s", path, err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != "activated" {
+			t.Errorf("GET %s: unexpected body %q", path, body)
+		}
+
+		if path != "/" {
+			continue
+		}
+
+		// served, so listeners matching no server are closed by now
+		if conn, err := net.DialTimeout("tcp", stray.Addr().String(), time.Second); err == nil {
+			conn.Close()
+			t.Errorf("listener matching no server is not closed")
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("child: %s", err)
+	}
+}
@@ -2,13 +2,10 @@ package servers
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
-	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +14,7 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -47,6 +45,16 @@ func (it iterator) Listen(fnArgs ...Arg) (ss Servers, errs []error) {
 
 	fnLog := cfg.fnLog
 
+	var fds *listenFDs
+
+	if cfg.socketActivation {
+		var err error
+
+		if fds, err = newListenFDsFromEnv(); err != nil {
+			return nil, []error{err}
+		}
+	}
+
 	wg := sync.WaitGroup{}
 	wg.Add(it.Len())
 
@@ -60,43 +68,28 @@ func (it iterator) Listen(fnArgs ...Arg) (ss Servers, errs []error) {
 			addr := s.Addr()
 			network := s.Network()
 
-			if s.Kind().Has(KindUNIX) {
-				unix := s.(*ServerUNIX)
-				mode := unix.SocketFileMode
-
-				os.Remove(addr)
-				listener, err := net.Listen(network, addr)
-				if err != nil {
-					errsChan <- fmt.Errorf("listen %s (%s) server failed: %w", network, addr, err)
-					return
-				}
-
-				if err := os.Chmod(addr, 0o777); err != nil {
-					errsChan <- fmt.Errorf("chmod on unix socket (%s) failed: %w", addr, err)
+			if fds != nil {
+				if listener := fds.take(s); listener != nil {
+					fnLog(xlog.Info, "%s inherited listener %s (%s)", runLogPrefix(s), addr, listener.Addr())
 
-					listener.Close()
+					serversChan <- &ServerListener{Server: s, Listener: listener}
 
 					return
 				}
+			}
 
-				<-time.After(1 * time.Second)
-
-				if err := os.Chmod(addr, mode); err != nil {
-					errsChan <- fmt.Errorf("changing permissions to unix socket %s failed: %w", addr, err)
-
-					listener.Close()
-
+			if s.Kind().Has(KindUNIX) {
+				listener, err := listenUNIX(s.(*ServerUNIX), fnLog)
+				if err != nil {
+					errsChan <- newListenerError(s, PhaseListen, err)
 					return
 				}
 
-				fnLog(xlog.Info, `{"status": "chmod OK", "perms": "%03o | %s", "addr": %q, "cmd": "chmod %o %s"}`,
-					mode.Perm(), mode, addr, mode.Perm(), addr)
-
 				serversChan <- &ServerListener{Server: s, Listener: listener}
 			} else {
 				listener, err := net.Listen(network, addr)
 				if err != nil {
-					errsChan <- fmt.Errorf("listen %s (%s) server failed: %w", network, addr, err)
+					errsChan <- newListenerError(s, PhaseListen, err)
 					return
 				}
 
@@ -117,98 +110,77 @@ func (it iterator) Listen(fnArgs ...Arg) (ss Servers, errs []error) {
 		errs = append(errs, err)
 	}
 
+	if fds != nil {
+		for _, v := range fds.closeUntaken() {
+			fnLog(xlog.Warn, "inherited listener %s matches no server, closed", v)
+		}
+	}
+
 	return ss, errs
 }
 
-func (it iterator) ServeHTTP(fnNewHandler func(Server) http.Handler, fnArgs ...Arg) error {
+// serveEach runs fnServe for every listener in own goroutine.
+// fnServe must block until ctx is done or serving failed.
+// On first failure rest of listeners are canceled
+// and waited no longer than shutdown timeout.
+// Errors of all failed listeners are joined.
+func (it iterator) serveEach(cfg *args, fnServe func(context.Context, *ServerListener) error) error {
 	it = it.FilterListener()
 
-	cfg := args{}
-	cfg.defaultize()
-
-	for _, fn := range fnArgs {
-		fn(&cfg)
-	}
-
 	ctx := cfg.ctx
 	if ctx == nil {
 		ctx = context.TODO()
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	fnLog := cfg.fnLog
+	acmeHTTP01s, err := it.acmeHTTP01s()
+	if err != nil {
+		return err
+	}
 
-	errChan := make(chan error, it.Len())
+	cfg.acmeHTTP01s = acmeHTTP01s
 
-	wg := sync.WaitGroup{}
-	wg.Add(it.Len())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	fnOnErr := func(err error) {
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			errChan <- err
-		}
+	if cfg.health == nil {
+		cfg.health = NewHealth()
 	}
-	it(func(s Server) bool {
-		l := s.(*ServerListener)
 
-		go func(l *ServerListener) {
-			defer wg.Done()
+	// readiness goes down first, servers are stopped after delay,
+	// so load balancers stop sending new requests
+	serveCtx, serveCancel := context.WithCancel(context.Background())
+	defer serveCancel()
 
-			addr := l.Addr()
+	go func() {
+		<-ctx.Done()
 
-			fnLog(xlog.Info, "%s HTTP server starting on %s", runLogPrefix(l), addr)
+		cfg.health.Shutdown()
 
-			server := &http.Server{
-				Addr:     addr,
-				Handler:  fnNewHandler(l.Server),
-				ErrorLog: log.New(&fnLogHTTPError{&cfg.fnLogHTTPError}, "", 0),
+		if d := cfg.healthShutdownDelay; d > 0 {
+			cfg.fnLog(xlog.Info, "health is not serving, wait %s before shutdown", d)
 
-				// see: Potential slowloris attack GO-S2112
-				ReadHeaderTimeout: s.Base().HTTP.ReadHeaderTimeout,
+			select {
+			case <-time.After(d):
+			case <-serveCtx.Done():
 			}
+		}
 
-			go func() {
-				<-ctx.Done()
-
-				ctxTimeout, cancel := context.WithTimeout(context.Background(), cfg.fnShutdownTimeout())
-				defer cancel()
-
-				if err := server.Shutdown(ctxTimeout); err != nil {
-					fnLog(xlog.Info, "server (:addr %s) shutdown failed: %s", addr, err)
-
-					return
-				}
+		serveCancel()
+	}()
 
-				fnLog(xlog.Info, "%s HTTP server (:addr %s) shutdown OK", runLogPrefix(l), addr)
-			}()
+	errChan := make(chan error, it.Len())
 
-			if s.Kind().Has(KindUNIX) {
-				if err := server.Serve(l.Listener); err != nil {
-					fnOnErr(fmt.Errorf("serve unix (%s) failed: %w", addr, err))
-				}
-			} else {
-				inet := l.Server.(*ServerINET)
+	wg := sync.WaitGroup{}
+	wg.Add(it.Len())
 
-				tlsConfig, err := inet.newTLSConfig()
-				if err != nil {
-					fnOnErr(err)
-					return
-				}
+	it(func(s Server) bool {
+		l := s.(*ServerListener)
 
-				if tlsConfig != nil {
-					l.Listener = tls.NewListener(l.Listener, tlsConfig)
-					server.TLSConfig = tlsConfig
-				}
+		go func(l *ServerListener) {
+			defer wg.Done()
 
-				if err := server.Serve(l.Listener); err != nil {
-					serverType := "http"
-					if inet.TLS.Enable {
-						serverType = "https"
-					}
-					fnOnErr(fmt.Errorf("starting %s (%s) server failed: %w", serverType, addr, err))
-				}
+			if err := fnServe(serveCtx, l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errChan <- newListenerError(l, PhaseServe, err)
 			}
 		}(l)
 
@@ -219,9 +191,24 @@ func (it iterator) ServeHTTP(fnNewHandler func(Server) http.Handler, fnArgs ...A
 
 	go func() { wg.Wait(); close(done) }()
 
+	// errors sent before listeners are done,
+	// so collect them once waiting is over
+	collect := func() error {
+		var errs []error
+
+		for {
+			select {
+			case err := <-errChan:
+				errs = append(errs, err)
+			default:
+				return joinErrors(errs...)
+			}
+		}
+	}
+
 	select {
 	case <-done:
-		return nil
+		return collect()
 
 	case err := <-errChan:
 		cancel()
@@ -239,15 +226,14 @@ func (it iterator) ServeHTTP(fnNewHandler func(Server) http.Handler, fnArgs ...A
 		select {
 		case <-done:
 		case <-deadline.C:
+			cfg.fnLog(xlog.Warn, "listeners were not shutdown in %s", cfg.fnShutdownTimeout())
 		}
 
-		return err
+		return joinErrors(err, collect())
 	}
 }
 
-func (it iterator) ServeGRPC(fnNewServer func(s Server, opts ...grpc.ServerOption) *grpc.Server, fnArgs ...Arg) error {
-	it = it.FilterListener()
-
+func newArgs(fnArgs ...Arg) *args {
 	cfg := args{}
 	cfg.defaultize()
 
@@ -255,101 +241,206 @@ func (it iterator) ServeGRPC(fnNewServer func(s Server, opts ...grpc.ServerOptio
 		fn(&cfg)
 	}
 
-	ctx := cfg.ctx
-	if ctx == nil {
-		ctx = context.TODO()
+	return &cfg
+}
+
+// newHTTPHandler builds user handler wrapped by
+// pprof, health and metrics endpoints (if enabled).
+func newHTTPHandler(s Server, fnNewHandler func(Server) http.Handler, cfg *args) http.Handler {
+	handler := newPprofHandler(s, fnNewHandler, cfg)
+
+	if c := &s.Base().Health; c.Enable {
+		handler = withHealth(c, cfg.health, handler)
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	if inet, ok := s.(*ServerINET); ok && inet.ACMEHTTP01 {
+		handler = withACMEHTTP01(cfg.acmeHTTP01s, handler)
+	}
 
-	fnLog := cfg.fnLog
+	if c := &s.Base().Metrics; c.Enable {
+		if cfg.metrics != nil {
+			handler = withMetrics(c.Path, cfg.metrics, handler)
+		} else {
+			cfg.fnLog(xlog.Warn, "%s metrics are enabled on %s but no metrics given, skip",
+				runLogPrefix(s), s.Addr())
+		}
+	}
 
-	errChan := make(chan error, it.Len())
+	return cfg.metrics.handler(s, handler)
+}
 
-	wg := sync.WaitGroup{}
-	wg.Add(it.Len())
+// newHTTPServer builds http.Server for listener
+// with shutdown bound to ctx.
+// afterShutdown (if any) is called once Shutdown returned.
+// Returned wait blocks until shutdown is done and returns its error.
+func newHTTPServer(
+	ctx context.Context, cfg *args, l *ServerListener, handler http.Handler, afterShutdown func(),
+) (server *http.Server, wait func() error) {
+	addr := l.Addr()
+
+	server = &http.Server{
+		Addr:     addr,
+		Handler:  handler,
+		ErrorLog: log.New(&fnLogHTTPError{&cfg.fnLogHTTPError}, "", 0),
+	}
+
+	l.Base().HTTP.apply(server)
+
+	errChan := make(chan error, 1)
+
+	go func() {
+		<-ctx.Done()
+
+		ctxTimeout, cancel := context.WithTimeout(context.Background(), cfg.fnShutdownTimeout())
+		defer cancel()
+
+		err := server.Shutdown(ctxTimeout)
 
-	fnOnErr := func(err error) {
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			errChan <- err
+		if afterShutdown != nil {
+			afterShutdown()
 		}
-	}
 
-	it(func(s Server) bool {
-		l := s.(*ServerListener)
+		if err != nil {
+			cfg.fnLog(xlog.Info, "server (:addr %s) shutdown failed: %s", addr, err)
 
-		go func(l *ServerListener) {
-			defer wg.Done()
+			errChan <- newListenerError(l, PhaseShutdown, err)
 
-			inet := l.Server.(*ServerINET)
+			return
+		}
 
-			addr := l.Addr()
+		cfg.fnLog(xlog.Info, "%s HTTP server (:addr %s) shutdown OK", runLogPrefix(l), addr)
 
-			var opts []grpc.ServerOption
+		errChan <- nil
+	}()
 
-			fnLog(xlog.Info, "%s gRPC server starting on %s", runLogPrefix(l), addr)
+	return server, func() error { return <-errChan }
+}
 
-			tlsConfig, err := inet.newTLSConfig()
-			if err != nil {
-				fnOnErr(err)
-				return
-			}
+// newServeListener wraps raw listener of l with metrics
+// and PROXY protocol (inet only) layers.
+// l.Listener is kept raw, it's handed over on upgrade.
+func newServeListener(cfg *args, l *ServerListener) net.Listener {
+	listener := cfg.metrics.listener(l.Server, l.Listener)
 
-			if tlsConfig != nil {
-				opt := grpc.Creds(credentials.NewTLS(tlsConfig))
-				opts = append(opts, opt)
-			}
+	if inet, ok := l.Server.(*ServerINET); ok {
+		listener = inet.ProxyProtocol.listener(listener)
+	}
 
-			server := fnNewServer(l.Server, opts...)
+	return listener
+}
 
-			if inet.GRPC.Reflection {
-				reflection.Register(server)
-			}
+// serveHTTPServer serves server on listener,
+// once server is closed waits for its shutdown.
+func serveHTTPServer(server *http.Server, listener net.Listener, wait func() error) error {
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 
-			go func() {
-				<-ctx.Done()
+	return wait()
+}
 
-				server.GracefulStop()
-				fnLog(xlog.Info, "%s gRPC server (:addr %s) shutdown OK", runLogPrefix(l), addr)
-			}()
+func (it iterator) ServeHTTP(fnNewHandler func(Server) http.Handler, fnArgs ...Arg) error {
+	cfg := newArgs(fnArgs...)
 
-			if err := server.Serve(l.Listener); err != nil {
-				fnOnErr(fmt.Errorf("starting gRPC (%s) server failed: %w", addr, err))
-			}
-		}(l)
+	return it.serveEach(cfg, func(ctx context.Context, l *ServerListener) error {
+		return serveHTTP(ctx, cfg, l, fnNewHandler)
+	})
+}
 
-		return true
+func (it iterator) ServeGRPC(fnNewServer func(s Server, opts ...grpc.ServerOption) *grpc.Server, fnArgs ...Arg) error {
+	cfg := newArgs(fnArgs...)
+
+	return it.serveEach(cfg, func(ctx context.Context, l *ServerListener) error {
+		return serveGRPC(ctx, cfg, l, fnNewServer)
 	})
+}
 
-	done := make(chan struct{})
+// serveHTTP serves http listener until ctx is done.
+func serveHTTP(ctx context.Context, cfg *args, l *ServerListener, fnNewHandler func(Server) http.Handler) error {
+	fnLog := cfg.fnLog
+	addr := l.Addr()
 
-	go func() { wg.Wait(); close(done) }()
+	fnLog(xlog.Info, "%s HTTP server starting on %s", runLogPrefix(l), addr)
 
-	select {
-	case <-done:
-		return nil
+	server, wait := newHTTPServer(ctx, cfg, l, newHTTPHandler(l.Server, fnNewHandler, cfg), nil)
 
-	case err := <-errChan:
-		cancel()
+	listener := newServeListener(cfg, l)
 
-		deadline := time.NewTimer(cfg.fnShutdownTimeout())
-		defer func() {
-			if !deadline.Stop() {
-				select {
-				case <-deadline.C:
-				default:
-				}
-			}
-		}()
+	if l.Kind().Has(KindUNIX) {
+		return serveHTTPServer(server, listener, wait)
+	}
 
-		select {
-		case <-done:
-		case <-deadline.C:
+	inet := l.Server.(*ServerINET)
+
+	tlsConfig, err := inet.newTLSConfig(ctx, fnLog)
+	if err != nil {
+		return newListenerError(l, PhaseTLSLoad, err)
+	}
+
+	if tlsConfig != nil {
+		server.TLSConfig = tlsConfig
+
+		if inet.ClientAuth.TLS.Enable {
+			applyClientIdentity(server)
 		}
 
-		return err
+		if _, err := l.Base().HTTP.configureHTTP2(server); err != nil {
+			return err
+		}
+
+		listener = cfg.metrics.tlsListener(l.Server, listener, server)
+	}
+
+	return serveHTTPServer(server, listener, wait)
+}
+
+// serveGRPC serves gRPC listener until ctx is done.
+func serveGRPC(
+	ctx context.Context, cfg *args, l *ServerListener,
+	fnNewServer func(s Server, opts ...grpc.ServerOption) *grpc.Server,
+) error {
+	fnLog := cfg.fnLog
+	addr := l.Addr()
+
+	opts := append(l.Base().GRPC.serverOptions(), cfg.metrics.serverOptions(l.Server)...)
+
+	fnLog(xlog.Info, "%s gRPC server starting on %s", runLogPrefix(l), addr)
+
+	// UNIX sockets are served in plaintext, TLS is inet only
+	if inet, ok := l.Server.(*ServerINET); ok {
+		tlsConfig, err := inet.newTLSConfig(ctx, fnLog)
+		if err != nil {
+			return newListenerError(l, PhaseTLSLoad, err)
+		}
+
+		if tlsConfig != nil {
+			opt := grpc.Creds(cfg.metrics.creds(l.Server, credentials.NewTLS(tlsConfig)))
+			opts = append(opts, opt)
+
+			if inet.ClientAuth.TLS.Enable {
+				opts = append(opts, grpc.StatsHandler(clientIdentityStatsHandler{}))
+			}
+		}
+	}
+
+	server := fnNewServer(l.Server, opts...)
+
+	if l.Base().GRPC.Reflection {
+		reflection.Register(server)
 	}
+
+	if l.Base().Health.Enable {
+		healthpb.RegisterHealthServer(server, cfg.health.GRPC())
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		server.GracefulStop()
+		fnLog(xlog.Info, "%s gRPC server (:addr %s) shutdown OK", runLogPrefix(l), addr)
+	}()
+
+	return server.Serve(newServeListener(cfg, l))
 }
 
 func (it iterator) Close() (errs []error) {
@@ -366,7 +457,7 @@ func (it iterator) Close() (errs []error) {
 		go func(l *ServerListener) {
 			defer wg.Done()
 
-			errsChan <- l.Close()
+			errsChan <- newListenerError(l, PhaseShutdown, l.Close())
 		}(l)
 
 		return true
@@ -0,0 +1,184 @@
+package servers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-x-pkg/log"
+)
+
+const unixSocketProbeTimeout = time.Second
+
+// unixListener removes socket file on Close.
+// Socket is bound in temporary dir and renamed, so net.UnixListener
+// knows only temporary path and can't unlink it itself.
+type unixListener struct {
+	*net.UnixListener
+
+	path   string
+	unlink bool
+}
+
+// Addr is configured path, not temporary one socket is bound to.
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: l.UnixListener.Addr().Network()}
+}
+
+func (l *unixListener) SetUnlinkOnClose(v bool) { l.unlink = v }
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+
+	if l.unlink {
+		os.Remove(l.path)
+	}
+
+	return err
+}
+
+// removeStaleUNIXSocket removes socket left by dead process.
+// Non-socket files and sockets still accepting connections are never removed.
+func removeStaleUNIXSocket(network, path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("stat unix socket (%s): %w", path, err)
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("(:path %q :mode %s): %w", path, fi.Mode(), ErrUnixSocketPathNotSocket)
+	}
+
+	conn, err := net.DialTimeout(network, path, unixSocketProbeTimeout)
+	if err == nil {
+		conn.Close()
+
+		return fmt.Errorf("(:path %q): %w", path, ErrUnixSocketInUse)
+	}
+
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("(:path %q): %s: %w", path, err, ErrUnixSocketInUse)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove stale unix socket (%s): %w", path, err)
+	}
+
+	return nil
+}
+
+// listenUNIX creates socket with final mode and owner before it's reachable:
+// socket is bound inside private (0700) temporary dir, chmod-ed, chown-ed
+// and atomically renamed to configured path.
+// Abstract sockets have no file and are listened as is.
+func listenUNIX(s *ServerUNIX, fnLog log.FnT) (net.Listener, error) {
+	network, path := s.Network(), s.Addr()
+	mode := s.SocketFileMode
+
+	if s.IsAbstract() {
+		listener, err := net.Listen(network, path)
+		if err != nil {
+			return nil, fmt.Errorf("listen %s (%s) abstract server failed: %w", network, path, err)
+		}
+
+		return listener, nil
+	}
+
+	uid, gid, err := s.socketOwnership()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := removeStaleUNIXSocket(network, path); err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(path), ".sock-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir for unix socket (%s): %w", path, err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, filepath.Base(path))
+
+	listener, err := net.Listen(network, tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s (%s) server failed: %w", network, path, err)
+	}
+
+	ul, ok := listener.(*net.UnixListener)
+	if !ok {
+		listener.Close()
+
+		return nil, fmt.Errorf("listen %s (%s): unexpected listener %T", network, path, listener)
+	}
+
+	ul.SetUnlinkOnClose(false)
+
+	fail := func(err error) (net.Listener, error) {
+		ul.Close()
+
+		return nil, err
+	}
+
+	if err := os.Chmod(tmpPath, mode.Perm()); err != nil {
+		return fail(newListenerError(s, PhaseChmod, err))
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(tmpPath, uid, gid); err != nil {
+			return fail(newListenerError(s, PhaseChown, err))
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fail(fmt.Errorf("move unix socket to %s failed: %w", path, err))
+	}
+
+	fnLog(log.Info, `{"status": "chmod OK", "perms": "%03o | %s", "owner": "%d:%d", "addr": %q, "cmd": "chmod %o %s"}`,
+		mode.Perm(), mode, uid, gid, path, mode.Perm(), path)
+
+	return &unixListener{UnixListener: ul, path: path, unlink: true}, nil
+}
+
+// socketOwnership resolves socketOwner and socketGroup (names or numeric ids).
+// -1 means keep as is.
+func (s *ServerUNIX) socketOwnership() (uid, gid int, err error) {
+	uid, gid = -1, -1
+
+	if v := s.SocketOwner; v != "" {
+		if uid, err = strconv.Atoi(v); err != nil {
+			u, e := user.Lookup(v)
+			if e != nil {
+				return -1, -1, fmt.Errorf("unix socket owner %q: %w", v, e)
+			}
+
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return -1, -1, fmt.Errorf("unix socket owner %q uid: %w", v, err)
+			}
+		}
+	}
+
+	if v := s.SocketGroup; v != "" {
+		if gid, err = strconv.Atoi(v); err != nil {
+			g, e := user.LookupGroup(v)
+			if e != nil {
+				return -1, -1, fmt.Errorf("unix socket group %q: %w", v, e)
+			}
+
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return -1, -1, fmt.Errorf("unix socket group %q gid: %w", v, err)
+			}
+		}
+	}
+
+	return uid, gid, nil
+}
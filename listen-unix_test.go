@@ -0,0 +1,124 @@
+package servers_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/servers"
+)
+
+func TestListenUNIX(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "app.sock")
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [unix, http]
+  addr: %s
+  socketFileMode: 0600
+  socketGroup: "%d"`, sock, os.Getgid()))
+
+	listeners := listenTestServers(t, ss)
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatalf("stat socket: %s", err)
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0o600 {
+		t.Errorf("unexpected socket mode %s", fi.Mode())
+	}
+
+	// live socket must not be stolen
+	if _, errs := ss.Listen(); len(errs) == 0 || !errors.Is(errs[0], servers.ErrUnixSocketInUse) {
+		t.Errorf("expected socket in use error, got %v", errs)
+	}
+
+	listeners.Close()
+
+	if _, err := os.Stat(sock); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket must be removed on close: %v", err)
+	}
+
+	// stale socket is replaced
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, errs := ss.Listen()
+	if len(errs) != 0 {
+		t.Fatalf("listen over stale socket: %v", errs)
+	}
+
+	listeners.Close()
+
+	// regular file is never removed
+	if err := os.WriteFile(sock, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, errs := ss.Listen(); len(errs) == 0 || !errors.Is(errs[0], servers.ErrUnixSocketPathNotSocket) {
+		t.Errorf("expected not a socket error, got %v", errs)
+	}
+
+	if data, _ := os.ReadFile(sock); string(data) != "data" {
+		t.Errorf("regular file must be kept")
+	}
+}
+
+func TestListenUNIXAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("linux only")
+	}
+
+	addr := fmt.Sprintf("@servers-test-%d", os.Getpid())
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [unix, http]
+  addr: "%s"`, addr))
+
+	listeners := listenTestServers(t, ss)
+
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Fatalf("dial abstract socket: %s", err)
+	}
+	conn.Close()
+
+	if got := listenerAddr(t, listeners, ss[0].Server); got != addr {
+		t.Errorf("unexpected listener addr %q", got)
+	}
+
+	w := bytes.Buffer{}
+	dctx := dumpctx.Ctx{}
+	dctx.Init()
+
+	ss.Dump(&dctx, &w)
+
+	if !strings.Contains(w.String(), "# abstract") {
+		t.Errorf("dump must mark abstract socket:\n%s", w.String())
+	}
+
+	var invalid servers.Servers
+
+	if err := yamlUnmarshal(`- kind: unix
+  addr: "@foo"
+  socketOwner: nobody`, &invalid); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid.Defaultize("", 0, "")
+
+	if err := invalid.Validate(); !errors.Is(err, servers.ErrUnixAbstractOwnership) {
+		t.Errorf("expected ownership error, got %v", err)
+	}
+}
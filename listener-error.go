@@ -0,0 +1,55 @@
+package servers
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ListenerPhase is lifecycle step listener failed on.
+type ListenerPhase string
+
+const (
+	PhaseListen   ListenerPhase = "listen"
+	PhaseChmod    ListenerPhase = "chmod"
+	PhaseChown    ListenerPhase = "chown"
+	PhaseTLSLoad  ListenerPhase = "tls-load"
+	PhaseServe    ListenerPhase = "serve"
+	PhaseShutdown ListenerPhase = "shutdown"
+)
+
+// ListenerError is error of single listener returned by Listen, Serve* and Close.
+// Errors of several listeners are joined (errors.Join alike):
+// errors.Is/As walk all of them, iterate Unwrap() []error to get each.
+type ListenerError struct {
+	Server Server
+	Addr   string
+	Kind   Kind
+	Phase  ListenerPhase
+	Err    error
+}
+
+func (e *ListenerError) Error() string {
+	return fmt.Sprintf("%s listener (:addr %s :kind %s) %s failed: %s",
+		runLogPrefix(e.Server), e.Addr, e.Kind, e.Phase, e.Err)
+}
+
+func (e *ListenerError) Unwrap() error { return e.Err }
+
+// newListenerError wraps err into ListenerError of phase,
+// err already being ListenerError is returned as is.
+func newListenerError(s Server, phase ListenerPhase, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var le *ListenerError
+	if errors.As(err, &le) {
+		return err
+	}
+
+	if l, ok := s.(*ServerListener); ok {
+		s = l.Server
+	}
+
+	return &ListenerError{Server: s, Addr: s.Addr(), Kind: s.Kind(), Phase: phase, Err: err}
+}
@@ -0,0 +1,77 @@
+package servers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/go-x-pkg/servers"
+)
+
+func TestListenerErrorListen(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	port := busy.Addr().(*net.TCPAddr).Port
+
+	ss := newTestServers(t, `- kind: [inet, http]
+  host: 127.0.0.1
+  port: `+strconv.Itoa(port))
+
+	listeners, errs := ss.Listen()
+	defer listeners.Close()
+
+	if len(errs) != 1 {
+		t.Fatalf("expected one error, got %v", errs)
+	}
+
+	var le *servers.ListenerError
+	if !errors.As(errs[0], &le) {
+		t.Fatalf("not ListenerError: %#v", errs[0])
+	}
+
+	if le.Phase != servers.PhaseListen || le.Addr != ss[0].Addr() || !le.Kind.Has(servers.KindHTTP) {
+		t.Errorf("unexpected listener error: %+v", le)
+	}
+}
+
+func TestListenerErrorTLSLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	cert := newTestCert(t, dir, "server", nil, testCertOpts{cn: "server"})
+	certFile, keyFile := cert.certFile, cert.keyFile
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q`, certFile, keyFile))
+
+	// broken after validate
+	writeTestFile(t, certFile, []byte("garbage"))
+
+	listeners := listenTestServers(t, ss)
+
+	err := listeners.ServeHTTP(serveOneBody("app"), servers.Context(context.Background()))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	var le *servers.ListenerError
+	if !errors.As(err, &le) {
+		t.Fatalf("not ListenerError: %#v", err)
+	}
+
+	if le.Phase != servers.PhaseTLSLoad || le.Server != ss[1].Server {
+		t.Errorf("unexpected listener error: %+v", le)
+	}
+}
@@ -0,0 +1,490 @@
+package servers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const metricsNamespace = "servers"
+
+// Metrics is prometheus collectors for listeners, connections,
+// TLS handshakes, HTTP requests and gRPC calls.
+// Collectors are registered in caller-supplied registry only.
+type Metrics struct {
+	gatherer prometheus.Gatherer
+
+	connsAccepted *prometheus.CounterVec
+	connsActive   *prometheus.GaugeVec
+	connsClosed   *prometheus.CounterVec
+
+	tlsHandshakeFailures *prometheus.CounterVec
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	grpcHandled  *prometheus.CounterVec
+	grpcDuration *prometheus.HistogramVec
+}
+
+// NewMetrics creates and registers collectors in reg.
+// If reg is prometheus.Gatherer (e.g. *prometheus.Registry)
+// it's exposed on listeners with metrics enabled.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		connsAccepted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "connections_accepted_total",
+			Help: "Connections accepted by listener.",
+		}, []string{"listener"}),
+		connsActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "connections_active",
+			Help: "Connections currently open on listener.",
+		}, []string{"listener"}),
+		connsClosed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "connections_closed_total",
+			Help: "Connections closed on listener.",
+		}, []string{"listener"}),
+
+		tlsHandshakeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "tls_handshake_failures_total",
+			Help: "Failed TLS handshakes by reason.",
+		}, []string{"listener", "reason"}),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "http_requests_total",
+			Help: "HTTP requests by status code.",
+		}, []string{"listener", "method", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Name: "http_request_duration_seconds",
+			Help:    "HTTP request latency by status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"listener", "method", "code"}),
+
+		grpcHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "grpc_handled_total",
+			Help: "gRPC calls by method and status code.",
+		}, []string{"listener", "type", "method", "code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Name: "grpc_handling_seconds",
+			Help:    "gRPC call latency by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"listener", "type", "method"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.connsAccepted, m.connsActive, m.connsClosed,
+		m.tlsHandshakeFailures,
+		m.httpRequests, m.httpDuration,
+		m.grpcHandled, m.grpcDuration,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if g, ok := reg.(prometheus.Gatherer); ok {
+		m.gatherer = g
+	}
+
+	return m, nil
+}
+
+// Handler exposes registry metrics were registered in.
+func (m *Metrics) Handler() http.Handler {
+	if m.gatherer == nil {
+		return http.NotFoundHandler()
+	}
+
+	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{})
+}
+
+// MetricsConfig exposes metrics registry (see WithMetrics) on HTTP path.
+type MetricsConfig struct {
+	Enable bool   `json:"enable" yaml:"enable" bson:"enable"`
+	Path   string `json:"path" yaml:"path" bson:"path"`
+}
+
+// withMetrics serves m on path and everything else with next.
+func withMetrics(path string, m *Metrics, next http.Handler) http.Handler {
+	handler := m.Handler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// metricsListenerLabel is configured (not bound) address,
+// it's stable across restarts.
+func metricsListenerLabel(s Server) string { return s.Addr() }
+
+// listener counts accepted, active and closed connections.
+// Methods below are no-op on nil Metrics.
+func (m *Metrics) listener(s Server, listener net.Listener) net.Listener {
+	if m == nil {
+		return listener
+	}
+
+	label := metricsListenerLabel(s)
+
+	return &metricsListener{
+		Listener: listener,
+		accepted: m.connsAccepted.WithLabelValues(label),
+		active:   m.connsActive.WithLabelValues(label),
+		closed:   m.connsClosed.WithLabelValues(label),
+	}
+}
+
+type metricsListener struct {
+	net.Listener
+
+	accepted prometheus.Counter
+	active   prometheus.Gauge
+	closed   prometheus.Counter
+}
+
+func (l *metricsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.accepted.Inc()
+	l.active.Inc()
+
+	return &metricsConn{Conn: conn, l: l}, nil
+}
+
+type metricsConn struct {
+	net.Conn
+
+	l      *metricsListener
+	closed int32
+}
+
+func (c *metricsConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.l.active.Dec()
+		c.l.closed.Inc()
+	}
+
+	return c.Conn.Close()
+}
+
+func (m *Metrics) onTLSHandshakeFailure(s Server) func(error) {
+	counter := m.tlsHandshakeFailures.MustCurryWith(prometheus.Labels{"listener": metricsListenerLabel(s)})
+
+	return func(err error) { counter.WithLabelValues(tlsHandshakeFailureReason(err)).Inc() }
+}
+
+// tlsListener terminates TLS for server,
+// handshake failures are counted.
+func (m *Metrics) tlsListener(s Server, listener net.Listener, server *http.Server) net.Listener {
+	if m == nil {
+		return tls.NewListener(listener, server.TLSConfig)
+	}
+
+	return newHandshakeListener(listener, server.TLSConfig, tlsHandshakeTimeout(server), m.onTLSHandshakeFailure(s))
+}
+
+// tlsHandshakeTimeout is same as http.Server uses for handshake.
+func tlsHandshakeTimeout(server *http.Server) time.Duration {
+	var d time.Duration
+
+	for _, v := range []time.Duration{server.ReadHeaderTimeout, server.ReadTimeout, server.WriteTimeout} {
+		if v > 0 && (d == 0 || v < d) {
+			d = v
+		}
+	}
+
+	return d
+}
+
+// creds counts failed gRPC TLS handshakes.
+func (m *Metrics) creds(s Server, creds credentials.TransportCredentials) credentials.TransportCredentials {
+	if m == nil {
+		return creds
+	}
+
+	return &metricsCreds{TransportCredentials: creds, onFail: m.onTLSHandshakeFailure(s)}
+}
+
+// tlsHandshakeFailureReason maps handshake error to low-cardinality reason.
+func tlsHandshakeFailureReason(err error) string {
+	var (
+		ne  net.Error
+		rhe tls.RecordHeaderError
+	)
+
+	switch msg := err.Error(); {
+	case errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.Is(err, io.EOF), strings.Contains(msg, "connection reset"):
+		return "eof"
+	case errors.As(err, &rhe):
+		return "not_tls"
+	case strings.Contains(msg, "unsupported versions"), strings.Contains(msg, "protocol version"):
+		return "version"
+	case strings.Contains(msg, "cipher"):
+		return "cipher"
+	case strings.Contains(msg, "certificate"), strings.Contains(msg, "x509"):
+		return "certificate"
+	case strings.Contains(msg, "bad record MAC"), strings.Contains(msg, "alert"):
+		return "alert"
+	default:
+		return "other"
+	}
+}
+
+// handler counts HTTP requests and latency by status code.
+func (m *Metrics) handler(s Server, next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+
+	label := prometheus.Labels{"listener": metricsListenerLabel(s)}
+	requests := m.httpRequests.MustCurryWith(label)
+	duration := m.httpDuration.MustCurryWith(label)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(sw, r)
+
+		code := strconv.Itoa(sw.code())
+
+		requests.WithLabelValues(r.Method, code).Inc()
+		duration.WithLabelValues(r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+
+	status int
+}
+
+func (w *statusWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is counted as 101 Switching Protocols (websocket and alike)
+// unless status is already written.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T: %w", w.ResponseWriter, http.ErrNotSupported)
+	}
+
+	conn, rw, err := h.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, rw, err
+}
+
+func (w *statusWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// Unwrap returns underlying http.ResponseWriter
+// (http.ResponseController of go1.20 and later looks it up).
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// serverOptions returns gRPC interceptors counting calls and latency
+// by method and status code.
+func (m *Metrics) serverOptions(s Server) []grpc.ServerOption {
+	if m == nil {
+		return nil
+	}
+
+	label := prometheus.Labels{"listener": metricsListenerLabel(s)}
+	handled := m.grpcHandled.MustCurryWith(label)
+	duration := m.grpcDuration.MustCurryWith(label)
+
+	observe := func(typ, method string, start time.Time, err error) {
+		handled.WithLabelValues(typ, method, status.Code(err).String()).Inc()
+		duration.WithLabelValues(typ, method).Observe(time.Since(start).Seconds())
+	}
+
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(
+			ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			observe("unary", info.FullMethod, start, err)
+
+			return resp, err
+		}),
+		grpc.ChainStreamInterceptor(func(
+			srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+		) error {
+			start := time.Now()
+			err := handler(srv, ss)
+			observe("stream", info.FullMethod, start, err)
+
+			return err
+		}),
+	}
+}
+
+// metricsCreds counts failed gRPC TLS handshakes.
+type metricsCreds struct {
+	credentials.TransportCredentials
+
+	onFail func(error)
+}
+
+func (c *metricsCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	out, info, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		c.onFail(err)
+	}
+
+	return out, info, err
+}
+
+func (c *metricsCreds) Clone() credentials.TransportCredentials {
+	return &metricsCreds{TransportCredentials: c.TransportCredentials.Clone(), onFail: c.onFail}
+}
+
+// handshakeListener performs TLS handshake before connection is returned from Accept,
+// so handshake failures can be counted (http.Server only logs them).
+// Handshakes run concurrently, slow client doesn't block Accept.
+type handshakeListener struct {
+	net.Listener
+
+	config  *tls.Config
+	timeout time.Duration
+	onFail  func(error)
+
+	conns chan net.Conn
+	errs  chan error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newHandshakeListener(
+	listener net.Listener, config *tls.Config, timeout time.Duration, onFail func(error),
+) *handshakeListener {
+	l := &handshakeListener{
+		Listener: listener,
+		config:   config,
+		timeout:  timeout,
+		onFail:   onFail,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+
+	go l.acceptLoop()
+
+	return l
+}
+
+func (l *handshakeListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			continue
+		}
+
+		go l.handshake(conn)
+	}
+}
+
+func (l *handshakeListener) handshake(conn net.Conn) {
+	tlsConn := tls.Server(conn, l.config)
+
+	if l.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(l.timeout))
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		l.onFail(err)
+		conn.Close()
+
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	select {
+	case l.conns <- tlsConn:
+	case <-l.done:
+		tlsConn.Close()
+	}
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *handshakeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+
+	return l.Listener.Close()
+}
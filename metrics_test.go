@@ -0,0 +1,270 @@
+package servers_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// gatheredValue sums counters and gauges named name with matching labels.
+func gatheredValue(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatalf("gather: %s", err)
+	}
+
+	var sum float64
+
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if v, ok := labels[lp.GetName()]; ok && v != lp.GetValue() {
+					continue metrics
+				}
+			}
+
+			switch {
+			case m.GetCounter() != nil:
+				sum += m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				sum += m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	return sum
+}
+
+func TestMetrics(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	cert := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http, grpc]
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+  health:
+    enable: true
+  metrics:
+    enable: true`, cert.certFile, cert.keyFile))
+
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+	label := ss[0].Addr()
+
+	reg := prometheus.NewRegistry()
+
+	m, err := servers.NewMetrics(reg)
+	if err != nil {
+		t.Fatalf("new metrics: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeMux(
+			serveOneBody("app"),
+			func(_ servers.Server, opts ...grpc.ServerOption) *grpc.Server { return grpc.NewServer(opts...) },
+			servers.Context(ctx),
+			servers.WithMetrics(m),
+		)
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		ForceAttemptHTTP2: true,
+	}}
+	defer client.CloseIdleConnections()
+
+	get := func(path string) string {
+		t.Helper()
+
+		resp, err := client.Get("https://" + addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %s", path, err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		return string(body)
+	}
+
+	if body := get("/"); body != "app" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	// plaintext request to TLS listener
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n")
+	io.Copy(io.Discard, conn)
+	conn.Close()
+
+	grpcConn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, "")))
+	if err != nil {
+		t.Fatalf("grpc dial: %s", err)
+	}
+	defer grpcConn.Close()
+
+	ctxTimeout, cancelTimeout := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelTimeout()
+
+	if _, err := healthpb.NewHealthClient(grpcConn).Check(ctxTimeout, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("grpc health check: %s", err)
+	}
+
+	if body := get("/metrics"); !strings.Contains(body, "servers_http_requests_total") {
+		t.Errorf("metrics endpoint: %q", body)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"servers_http_requests_total", map[string]string{"listener": label, "method": "GET", "code": "200"}, 1},
+		{"servers_tls_handshake_failures_total", map[string]string{"listener": label, "reason": "not_tls"}, 1},
+		{"servers_grpc_handled_total", map[string]string{
+			"listener": label, "type": "unary", "method": "/grpc.health.v1.Health/Check", "code": "OK",
+		}, 1},
+		{"servers_connections_accepted_total", map[string]string{"listener": label}, 3},
+	} {
+		if got := gatheredValue(t, reg, tc.name, tc.labels); got < tc.want {
+			t.Errorf("%s%v = %v, want at least %v", tc.name, tc.labels, got, tc.want)
+		}
+	}
+
+	if got := gatheredValue(t, reg, "servers_connections_closed_total", nil); got < 1 {
+		t.Errorf("no closed connections counted")
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+}
+
+func TestMetricsHijack(t *testing.T) {
+	ss := newTestServers(t, `- kind: [inet, http]
+  host: 127.0.0.1
+  metrics:
+    enable: true`)
+
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	reg := prometheus.NewRegistry()
+
+	m, err := servers.NewMetrics(reg)
+	if err != nil {
+		t.Fatalf("new metrics: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(func(servers.Server) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if p, ok := w.(http.Pusher); !ok {
+					t.Errorf("response writer is not pusher: %T", w)
+				} else if err := p.Push("/app.js", nil); !errors.Is(err, http.ErrNotSupported) {
+					t.Errorf("push over http/1.1: expected %v, got %v", http.ErrNotSupported, err)
+				}
+
+				h, ok := w.(http.Hijacker)
+				if !ok {
+					t.Errorf("response writer is not hijacker: %T", w)
+					http.Error(w, "no hijack", http.StatusInternalServerError)
+
+					return
+				}
+
+				conn, rw, err := h.Hijack()
+				if err != nil {
+					t.Errorf("hijack: %s", err)
+					return
+				}
+				defer conn.Close()
+
+				rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+				rw.Flush()
+
+				line, _ := rw.ReadString('\n')
+				rw.WriteString(line)
+				rw.Flush()
+			})
+		}, servers.Context(ctx), servers.WithMetrics(m))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: app\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nping\n")
+
+	body, _ := io.ReadAll(conn)
+	if !strings.HasPrefix(string(body), "HTTP/1.1 101 ") || !strings.HasSuffix(string(body), "\r\n\r\nping\n") {
+		t.Fatalf("unexpected hijacked response %q", body)
+	}
+
+	labels := map[string]string{"listener": ss[0].Addr(), "method": "GET", "code": "101"}
+
+	if got := gatheredValue(t, reg, "servers_http_requests_total", labels); got != 1 {
+		t.Errorf("servers_http_requests_total%v = %v, want 1", labels, got)
+	}
+}
+
+func TestMetricsValidate(t *testing.T) {
+	var ss servers.Servers
+
+	if err := yamlUnmarshal(`- kind: [inet, grpc]
+  metrics:
+    enable: true`, &ss); err != nil {
+		t.Fatal(err)
+	}
+
+	ss.Defaultize("127.0.0.1", 0, "")
+
+	if err := ss.Validate(); err == nil {
+		t.Errorf("expected error for metrics on non-http server")
+	}
+}
@@ -0,0 +1,332 @@
+package servers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/fnspath"
+	"github.com/go-x-pkg/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSPStaplingConfig staples OCSP responses of served certificates
+// (tls.Certificate.OCSPStaple). Only good responses are stapled.
+type OCSPStaplingConfig struct {
+	Enable bool `json:"enable" yaml:"enable" bson:"enable"`
+	// File is DER OCSP response of certFile, re-read on refresh.
+	// Without file response is fetched from OCSP server
+	// of certificate (AIA), issuer must follow certificate in certFile.
+	// Certificates of tls.certificates have own ocspStapleFile.
+	File string `json:"file" yaml:"file" bson:"file"`
+	// Timeout of responder request.
+	Timeout time.Duration `json:"timeout" yaml:"timeout" bson:"timeout"`
+	// RetryInterval after failed refresh. Previous staple is served
+	// until its nextUpdate, unless certificate is reported not good.
+	RetryInterval time.Duration `json:"retryInterval" yaml:"retryInterval" bson:"retryInterval"`
+}
+
+func (c *OCSPStaplingConfig) defaultize() {
+	if c.Timeout == 0 {
+		c.Timeout = defaultOCSPStaplingTimeout
+	}
+
+	if c.RetryInterval == 0 {
+		c.RetryInterval = defaultOCSPStaplingRetryInterval
+	}
+}
+
+func (c *OCSPStaplingConfig) interpolate(interpolateFn func(string) string) {
+	c.File = interpolateFn(c.File)
+}
+
+func (c *OCSPStaplingConfig) validate() error {
+	if !c.Enable {
+		return nil
+	}
+
+	if c.Timeout < 0 || c.RetryInterval < 0 {
+		return fmt.Errorf("(:timeout %s :retryInterval %s): %w", c.Timeout, c.RetryInterval, ErrOCSPNegativeValue)
+	}
+
+	return validateOCSPStapleFile(c.File)
+}
+
+func (c *OCSPStaplingConfig) dump(ctx *dumpctx.Ctx, w io.Writer) {
+	fmt.Fprintf(w, "%senable: %t\n", ctx.Indent(), c.Enable)
+
+	if !c.Enable {
+		return
+	}
+
+	fmt.Fprintf(w, "%sfile: %q\n", ctx.Indent(), c.File)
+	fmt.Fprintf(w, "%stimeout: %s\n", ctx.Indent(), c.Timeout)
+	fmt.Fprintf(w, "%sretryInterval: %s\n", ctx.Indent(), c.RetryInterval)
+}
+
+func validateOCSPStapleFile(v string) error {
+	if v == "" {
+		return nil
+	}
+
+	if exists, err := fnspath.IsExists(v); err != nil {
+		return fmt.Errorf("ocsp staple file existence check failed: %w", err)
+	} else if !exists {
+		return fmt.Errorf("error (:path %q): %w", v, ErrOCSPStapleFileNotExists)
+	}
+
+	return nil
+}
+
+// ocspStaple is stapling state of loaded certificate.
+type ocspStaple struct {
+	resp        *ocsp.Response
+	nextRefresh time.Time
+	err         error
+}
+
+func (st *ocspStaple) state() string {
+	switch {
+	case st.resp != nil:
+		return "good"
+	case st.err != nil:
+		return "error"
+	default:
+		return "pending"
+	}
+}
+
+// nextOCSPRefresh is halfway to nextUpdate (or retryInterval
+// later if response has none), but not earlier than in a second.
+func nextOCSPRefresh(resp *ocsp.Response, retryInterval time.Duration) time.Time {
+	now := time.Now()
+	next := now.Add(retryInterval)
+
+	if !resp.NextUpdate.IsZero() {
+		next = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	}
+
+	if earliest := now.Add(time.Second); next.Before(earliest) {
+		next = earliest
+	}
+
+	return next
+}
+
+// fetchStaple reads staple file or asks responder of cert.
+// Response reporting certificate not good is returned along with error.
+func fetchStaple(ctx context.Context, client *http.Client, file string,
+	cert *tls.Certificate,
+) ([]byte, *ocsp.Response, error) {
+	var issuer *x509.Certificate
+
+	if len(cert.Certificate) > 1 {
+		parsed, err := x509.ParseCertificate(cert.Certificate[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("ocsp: parse issuer: %w", err)
+		}
+
+		issuer = parsed
+	}
+
+	var (
+		raw  []byte
+		resp *ocsp.Response
+		err  error
+	)
+
+	if file != "" {
+		if raw, err = os.ReadFile(file); err != nil {
+			return nil, nil, fmt.Errorf("ocsp: %w", err)
+		}
+
+		resp, err = parseOCSPResponse(raw, cert.Leaf, issuer)
+	} else {
+		switch {
+		case issuer == nil:
+			err = errors.New("ocsp: no issuer certificate in cert-file")
+		case len(cert.Leaf.OCSPServer) == 0:
+			err = errors.New("ocsp: certificate has no OCSP server")
+		default:
+			raw, resp, err = requestOCSP(ctx, client, cert.Leaf.OCSPServer[0], cert.Leaf, issuer)
+		}
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.Status != ocsp.Good {
+		return nil, resp, fmt.Errorf("ocsp: certificate status is %s", ocspStatusString(resp.Status))
+	}
+
+	return raw, resp, nil
+}
+
+func ocspStatusString(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// refreshStaples refreshes due staples and returns earliest next refresh.
+func (cs *CertSource) refreshStaples(ctx context.Context, cfg OCSPStaplingConfig, client *http.Client) time.Time {
+	cs.mu.RLock()
+	loaded := append([]*tls.Certificate(nil), cs.loaded...)
+	staples := append([]ocspStaple(nil), cs.staples...)
+	cs.mu.RUnlock()
+
+	var next time.Time
+
+	for i, cert := range loaded {
+		st := staples[i]
+
+		if time.Now().Before(st.nextRefresh) {
+			if next.IsZero() || st.nextRefresh.Before(next) {
+				next = st.nextRefresh
+			}
+
+			continue
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		raw, resp, err := fetchStaple(fetchCtx, client, cs.certs[i].OCSPStapleFile, cert)
+		cancel()
+
+		stapled := *cert
+
+		if err != nil {
+			st.err = err
+			st.nextRefresh = time.Now().Add(cfg.RetryInterval)
+
+			// keep previous staple until its nextUpdate,
+			// but never once certificate is revoked (or unknown)
+			if st.resp != nil && (resp != nil || !st.resp.NextUpdate.IsZero() && time.Now().After(st.resp.NextUpdate)) {
+				st.resp, stapled.OCSPStaple = nil, nil
+			}
+
+			cs.fnLog(log.Error, "tls ocsp stapling (:cert %q) failed, retry at %s: %s",
+				cs.certs[i].CertFile, st.nextRefresh.Format(time.RFC3339), err)
+		} else {
+			st = ocspStaple{resp: resp, nextRefresh: nextOCSPRefresh(resp, cfg.RetryInterval)}
+			stapled.OCSPStaple = raw
+
+			cs.fnLog(log.Info, "tls ocsp stapling (:cert %q) OK, next update %s, refresh at %s",
+				cs.certs[i].CertFile, resp.NextUpdate.Format(time.RFC3339), st.nextRefresh.Format(time.RFC3339))
+		}
+
+		if next.IsZero() || st.nextRefresh.Before(next) {
+			next = st.nextRefresh
+		}
+
+		cs.mu.Lock()
+		// certificates may be reloaded meanwhile
+		if i < len(cs.loaded) && cs.loaded[i] == cert {
+			cs.loaded[i] = &stapled
+			cs.staples[i] = st
+		}
+		cs.mu.Unlock()
+	}
+
+	return next
+}
+
+// stapleFirst fetches staples before certificates are served,
+// all of them within timeout.
+func (cs *CertSource) stapleFirst(ctx context.Context, cfg OCSPStaplingConfig) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	cs.refreshStaples(ctx, cfg, &http.Client{Timeout: cfg.Timeout})
+}
+
+// staple keeps OCSP staples of certificates fresh until ctx is done.
+// Reloaded certificates are stapled immediately.
+func (cs *CertSource) staple(ctx context.Context, cfg OCSPStaplingConfig) {
+	client := &http.Client{Timeout: cfg.Timeout}
+
+	for {
+		next := cs.refreshStaples(ctx, cfg, client)
+
+		wait := time.Until(next)
+		if next.IsZero() {
+			wait = cfg.RetryInterval
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-cs.reloaded:
+			timer.Stop()
+		}
+	}
+}
+
+// carryStaples keeps staples of certificates not changed by reload,
+// but refreshes them (staple file might be changed).
+// Must be called with cs.mu held.
+func (cs *CertSource) carryStaples(loaded []*tls.Certificate) []ocspStaple {
+	staples := make([]ocspStaple, len(loaded))
+
+	for i, cert := range loaded {
+		if i >= len(cs.loaded) || !bytes.Equal(cs.loaded[i].Certificate[0], cert.Certificate[0]) {
+			continue
+		}
+
+		cert.OCSPStaple = cs.loaded[i].OCSPStaple
+		staples[i].resp = cs.staples[i].resp
+	}
+
+	return staples
+}
+
+func (cs *CertSource) dumpStaples(ctx *dumpctx.Ctx, w io.Writer) {
+	cs.mu.RLock()
+	staples := append([]ocspStaple(nil), cs.staples...)
+	cs.mu.RUnlock()
+
+	fmt.Fprintf(w, "%sstaples (x%d):\n", ctx.Indent(), len(staples))
+
+	for i := range staples {
+		st := &staples[i]
+
+		ctx.WrapList(func() {
+			ctx.EmitPrefix(w)
+
+			fmt.Fprintf(w, "certFile: %s\n", cs.certs[i].CertFile)
+
+			ctx.Enter()
+			defer ctx.Leave()
+
+			fmt.Fprintf(w, "%sstate: %s\n", ctx.Indent(), st.state())
+
+			if st.resp != nil {
+				fmt.Fprintf(w, "%sthisUpdate: %s\n", ctx.Indent(), st.resp.ThisUpdate.Format(time.RFC3339))
+				fmt.Fprintf(w, "%snextUpdate: %s\n", ctx.Indent(), st.resp.NextUpdate.Format(time.RFC3339))
+			}
+
+			fmt.Fprintf(w, "%snextRefresh: %s\n", ctx.Indent(), st.nextRefresh.Format(time.RFC3339))
+
+			if st.err != nil {
+				fmt.Fprintf(w, "%serror: %s\n", ctx.Indent(), st.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package servers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/servers"
+	"golang.org/x/crypto/ocsp"
+)
+
+// writeTestChain writes certificate followed by its issuer.
+func writeTestChain(t *testing.T, path string, c, issuer *testCert) {
+	t.Helper()
+
+	writeTestFile(t, path, append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.cert.Raw})...))
+}
+
+func TestOCSPStapling(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+
+	statuses := map[string]int{}
+	responder, requests := newTestOCSPResponder(t, ca, 2*time.Second, statuses)
+
+	aia := newTestCert(t, dir, "aia", ca, testCertOpts{
+		cn: "aia", ips: []net.IP{net.IPv4(127, 0, 0, 1)}, ocspServer: responder.URL,
+	})
+	fromFile := newTestCert(t, dir, "file", ca, testCertOpts{cn: "file", dns: []string{"file.test"}})
+	unavailable := newTestCert(t, dir, "unavailable", ca, testCertOpts{
+		cn: "unavailable", dns: []string{"unavailable.test"}, ocspServer: responder.URL,
+	})
+
+	statuses[aia.cert.SerialNumber.String()] = ocsp.Good
+
+	staple, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: fromFile.cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}, ca.key)
+	if err != nil {
+		t.Fatalf("create ocsp response: %s", err)
+	}
+
+	stapleFile := filepath.Join(dir, "file.ocsp")
+	writeTestFile(t, stapleFile, staple)
+
+	aiaChain := filepath.Join(dir, "aia-chain.crt")
+	writeTestChain(t, aiaChain, aia, ca)
+
+	unavailableChain := filepath.Join(dir, "unavailable-chain.crt")
+	writeTestChain(t, unavailableChain, unavailable, ca)
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    certificates:
+    - certFile: %q
+      keyFile: %q
+      serverNames: [file.test]
+      ocspStapleFile: %q
+    - certFile: %q
+      keyFile: %q
+      serverNames: [unavailable.test]
+    ocspStapling:
+      enable: true
+      timeout: 1s
+      retryInterval: 50ms`,
+		aiaChain, aia.keyFile,
+		fromFile.certFile, fromFile.keyFile, stapleFile,
+		unavailableChain, unavailable.keyFile))
+
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx), servers.FnLog((&testLog{}).fn))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	stapled := func(serverName string) []byte {
+		t.Helper()
+
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, ServerName: serverName, MinVersion: tls.VersionTLS13})
+		if err != nil {
+			t.Fatalf("%s: dial: %s", serverName, err)
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().OCSPResponse
+	}
+
+	var first *ocsp.Response
+
+	for _, tt := range []struct {
+		serverName string
+		cert       *testCert
+	}{
+		{"127.0.0.1", aia},
+		{"file.test", fromFile},
+	} {
+		// fetched before serving, no wait
+		resp, err := ocsp.ParseResponseForCert(stapled(tt.serverName), tt.cert.cert, ca.cert)
+		if err != nil {
+			t.Fatalf("%s: parse stapled response: %s", tt.serverName, err)
+		}
+
+		if resp.Status != ocsp.Good {
+			t.Errorf("%s: unexpected stapled status %d", tt.serverName, resp.Status)
+		}
+
+		if first == nil {
+			first = resp
+		}
+	}
+
+	if raw := stapled("unavailable.test"); raw != nil {
+		t.Errorf("unexpected staple of unavailable certificate")
+	}
+
+	// refreshed halfway to nextUpdate
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		resp, err := ocsp.ParseResponseForCert(stapled("127.0.0.1"), aia.cert, ca.cert)
+		if err != nil {
+			t.Fatalf("parse refreshed response: %s", err)
+		}
+
+		if resp.ThisUpdate.After(first.ThisUpdate) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("staple is not refreshed, %d responder requests", atomic.LoadInt32(requests))
+		}
+	}
+
+	w := bytes.Buffer{}
+	dctx := dumpctx.Ctx{}
+	dctx.Init()
+
+	ss.Dump(&dctx, &w)
+
+	for _, v := range []string{
+		"staples (x3):", "state: good", "state: error", "nextRefresh: ", "nextUpdate: ", "status 500",
+	} {
+		if !strings.Contains(w.String(), v) {
+			t.Errorf("dump has no %q:\n%s", v, w.String())
+		}
+	}
+}
+
+func TestOCSPStaplingRevoked(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+
+	status := int32(ocsp.Good)
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       int(atomic.LoadInt32(&status)),
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now(),
+			NextUpdate:   time.Now().Add(4 * time.Second),
+			RevokedAt:    time.Now().Add(-time.Hour),
+		}, ca.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	defer responder.Close()
+
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{
+		cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}, ocspServer: responder.URL,
+	})
+
+	chain := filepath.Join(dir, "chain.crt")
+	writeTestChain(t, chain, srv, ca)
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    ocspStapling:
+      enable: true
+      timeout: 1s
+      retryInterval: 50ms`, chain, srv.keyFile))
+
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx), servers.FnLog((&testLog{}).fn))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	stapled := func() []byte {
+		t.Helper()
+
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13})
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().OCSPResponse
+	}
+
+	if raw := stapled(); raw == nil {
+		t.Fatalf("certificate is not stapled")
+	}
+
+	// refreshed halfway to nextUpdate, good staple is still valid
+	// by then, yet must not be served
+	atomic.StoreInt32(&status, ocsp.Revoked)
+
+	for deadline := time.Now().Add(3 * time.Second); stapled() != nil; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("staple of revoked certificate is served")
+		}
+	}
+}
+
+func TestOCSPStaplingValidate(t *testing.T) {
+	dir := t.TempDir()
+
+	cert := newTestCert(t, dir, "server", nil, testCertOpts{cn: "server"})
+
+	for i, tt := range []struct {
+		tls string
+		err error
+	}{
+		{fmt.Sprintf("certFile: %q\n    keyFile: %q\n    ocspStapling: {enable: true}", cert.certFile, cert.keyFile), nil},
+		{fmt.Sprintf("certFile: %q\n    keyFile: %q\n    ocspStapling: {enable: true, file: /nonexistent.ocsp}", cert.certFile, cert.keyFile), servers.ErrOCSPStapleFileNotExists},
+		{fmt.Sprintf("certificates:\n    - certFile: %q\n      keyFile: %q\n      ocspStapleFile: /nonexistent.ocsp", cert.certFile, cert.keyFile), servers.ErrOCSPStapleFileNotExists},
+		{fmt.Sprintf("acme: {enable: true, domains: [example.com], cacheDir: %q}\n    ocspStapling: {enable: true}", dir), servers.ErrOCSPStaplingACME},
+	} {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(fmt.Sprintf("- kind: [inet, http]\n  tls:\n    enable: true\n    %s", tt.tls), &ss); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+
+		ss.Defaultize("127.0.0.1", 0, "")
+
+		if err := ss.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("%d: expected %v, got %v", i, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package servers
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const ocspResponseMaxSize = 1 << 20
+
+// requestOCSP asks responder for status of leaf issued by issuer.
+// Response signature is verified, stale response is an error.
+func requestOCSP(ctx context.Context, client *http.Client, responder string,
+	leaf, issuer *x509.Certificate,
+) ([]byte, *ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ocsp: create request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, responder, bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, fmt.Errorf("ocsp: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ocsp: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("ocsp: responder (:url %q) status %d", responder, httpResp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, ocspResponseMaxSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("ocsp: read response: %w", err)
+	}
+
+	resp, err := parseOCSPResponse(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return raw, resp, nil
+}
+
+// parseOCSPResponse parses DER response for leaf, signature is verified
+// if issuer is known.
+func parseOCSPResponse(raw []byte, leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("ocsp: %w", err)
+	}
+
+	if resp.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		return nil, fmt.Errorf("ocsp: response is for serial %s", resp.SerialNumber.Text(16))
+	}
+
+	if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		return nil, fmt.Errorf("ocsp: stale response, next update %s", resp.NextUpdate.Format(time.RFC3339))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,273 @@
+package servers
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Overlay precedence, lowest to highest:
+//
+//	config file (yaml/json) < env <PREFIX>_<index>_<FIELD>
+//	  < env <PREFIX>_<NAME>_<FIELD> < flags
+//
+// Defaultize called afterwards only fills fields left empty.
+
+const (
+	overlayFieldHost             = "HOST"
+	overlayFieldPort             = "PORT"
+	overlayFieldAddr             = "ADDR"
+	overlayFieldTLSCertFile      = "TLS_CERT_FILE"
+	overlayFieldTLSKeyFile       = "TLS_KEY_FILE"
+	overlayFieldClientCACertFile = "CLIENT_AUTH_TLS_CA_CERT_FILE"
+)
+
+// serverNameRe is name usable as part of environment variable.
+var serverNameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// envKey is name as part of environment variable, e.g. public-api => PUBLIC_API.
+func envKey(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// overlayField sets field of server. INET server takes HOST, PORT,
+// ADDR (host:port) and TLS paths, UNIX one takes ADDR only.
+func overlayField(s Server, field, v string) error {
+	switch s := s.(type) {
+	case *ServerINET:
+		switch field {
+		case overlayFieldHost:
+			s.Host = v
+		case overlayFieldPort:
+			port, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				return fmt.Errorf("(:port %q): %w", v, ErrOverlayPortInvalid)
+			}
+
+			s.Port = int(port)
+		case overlayFieldAddr:
+			host, port, err := net.SplitHostPort(v)
+			if err != nil {
+				return fmt.Errorf("(:addr %q): %w", v, err)
+			}
+
+			s.Host = host
+
+			return overlayField(s, overlayFieldPort, port)
+		case overlayFieldTLSCertFile:
+			s.TLS.CertFile = v
+		case overlayFieldTLSKeyFile:
+			s.TLS.KeyFile = v
+		case overlayFieldClientCACertFile:
+			s.ClientAuth.TLS.CACertFile = v
+		default:
+			return ErrOverlayFieldUnknown
+		}
+	case *ServerUNIX:
+		switch field {
+		case overlayFieldAddr:
+			s.Address = v
+		case overlayFieldHost, overlayFieldPort, overlayFieldTLSCertFile,
+			overlayFieldTLSKeyFile, overlayFieldClientCACertFile:
+			return ErrOverlayFieldNotApplicable
+		default:
+			return ErrOverlayFieldUnknown
+		}
+	default:
+		return ErrOverlayFieldNotApplicable
+	}
+
+	return nil
+}
+
+// OverlayEnv overrides servers fields by environment variables
+// <prefix>_<index>_<FIELD> and <prefix>_<NAME>_<FIELD>
+// (NAME is upper-cased name with '-' replaced by '_'), e.g.
+// APP_SERVERS_0_PORT=8443, APP_SERVERS_PUBLIC_TLS_CERT_FILE=/etc/tls/tls.crt.
+// Fields are HOST, PORT, ADDR, TLS_CERT_FILE, TLS_KEY_FILE
+// and CLIENT_AUTH_TLS_CA_CERT_FILE. Name-keyed variables win over
+// index-keyed ones. Variable of prefix matching no server or field is an error,
+// so is name of server not usable in variable.
+func (ss Servers) OverlayEnv(prefix string) error {
+	prefix = strings.TrimSuffix(prefix, "_") + "_"
+
+	env := map[string]string{}
+
+	var keys []string
+
+	for _, kv := range os.Environ() {
+		if k, v := splitEnv(kv); strings.HasPrefix(k, prefix) {
+			k = strings.TrimPrefix(k, prefix)
+			env[k] = v
+			keys = append(keys, k)
+		}
+	}
+
+	// ADDR goes before HOST and PORT of the same server
+	sort.Strings(keys)
+
+	used := map[string]bool{}
+
+	apply := func(key string, s Server) error {
+		for _, k := range keys {
+			v := env[k]
+			field := strings.TrimPrefix(k, key+"_")
+			if field == k {
+				continue
+			}
+
+			err := overlayField(s, field, v)
+			if err == nil {
+				used[k] = true
+				continue
+			}
+
+			// e.g. name "api" and variable of server "api-v2"
+			if errors.Is(err, ErrOverlayFieldUnknown) {
+				continue
+			}
+
+			return fmt.Errorf("(:env %s%s): %w", prefix, k, err)
+		}
+
+		return nil
+	}
+
+	for i, s := range ss {
+		if err := apply(strconv.Itoa(i), s.Server); err != nil {
+			return err
+		}
+	}
+
+	names := map[string]bool{}
+
+	for _, s := range ss {
+		name := s.Base().Name
+		if name == "" {
+			continue
+		}
+
+		if !serverNameRe.MatchString(name) {
+			return fmt.Errorf("(:name %q): %w", name, ErrServerNameInvalid)
+		}
+
+		key := envKey(name)
+		if names[key] {
+			return fmt.Errorf("(:name %q): %w", name, ErrServerNameDuplicate)
+		}
+
+		names[key] = true
+
+		if err := apply(key, s.Server); err != nil {
+			return err
+		}
+	}
+
+	var unknown []string
+
+	for _, k := range keys {
+		if !used[k] {
+			unknown = append(unknown, prefix+k)
+		}
+	}
+
+	if len(unknown) != 0 {
+		return fmt.Errorf("(:env %s): %w", strings.Join(unknown, " "), ErrOverlayEnvUnknown)
+	}
+
+	return nil
+}
+
+func splitEnv(kv string) (string, string) {
+	if i := strings.IndexByte(kv, '='); i >= 0 {
+		return kv[:i], kv[i+1:]
+	}
+
+	return kv, ""
+}
+
+// Flags override first INET server (host, port, TLS paths)
+// and first UNIX server (addr), same ones Defaultize fills.
+// Only flags given on command line are applied.
+type Flags struct {
+	Host             string
+	Port             int
+	Addr             string
+	TLSCertFile      string
+	TLSKeyFile       string
+	ClientCACertFile string
+
+	fs     *flag.FlagSet
+	prefix string
+}
+
+// Register registers flags prefix+"host", "port", "addr",
+// "tls-cert-file", "tls-key-file" and "client-ca-cert-file" in fs.
+func (f *Flags) Register(fs *flag.FlagSet, prefix string) {
+	f.fs, f.prefix = fs, prefix
+
+	fs.StringVar(&f.Host, prefix+"host", "", "host of first inet server")
+	fs.IntVar(&f.Port, prefix+"port", 0, "port of first inet server")
+	fs.StringVar(&f.Addr, prefix+"addr", "", "socket path of first unix server")
+	fs.StringVar(&f.TLSCertFile, prefix+"tls-cert-file", "", "tls cert-file of first inet server")
+	fs.StringVar(&f.TLSKeyFile, prefix+"tls-key-file", "", "tls key-file of first inet server")
+	fs.StringVar(&f.ClientCACertFile, prefix+"client-ca-cert-file", "",
+		"client auth tls ca-cert-file of first inet server")
+}
+
+// OverlayFlags overrides servers fields by flags given on command line.
+func (ss Servers) OverlayFlags(f *Flags) error {
+	if f.fs == nil {
+		return nil
+	}
+
+	inet := ss.IntoIter().FilterInet().First()
+	unix := ss.IntoIter().FilterUnix().First()
+
+	var err error
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		if err != nil || !strings.HasPrefix(fl.Name, f.prefix) {
+			return
+		}
+
+		var (
+			target Server
+			field  string
+		)
+
+		switch strings.TrimPrefix(fl.Name, f.prefix) {
+		case "host":
+			target, field = inet, overlayFieldHost
+		case "port":
+			target, field = inet, overlayFieldPort
+		case "addr":
+			target, field = unix, overlayFieldAddr
+		case "tls-cert-file":
+			target, field = inet, overlayFieldTLSCertFile
+		case "tls-key-file":
+			target, field = inet, overlayFieldTLSKeyFile
+		case "client-ca-cert-file":
+			target, field = inet, overlayFieldClientCACertFile
+		default:
+			return
+		}
+
+		if target == nil {
+			err = fmt.Errorf("(:flag %s): %w", fl.Name, ErrOverlayNoServer)
+			return
+		}
+
+		if e := overlayField(target, field, fl.Value.String()); e != nil {
+			err = fmt.Errorf("(:flag %s): %w", fl.Name, e)
+		}
+	})
+
+	return err
+}
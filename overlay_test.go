@@ -0,0 +1,152 @@
+package servers_test
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/go-x-pkg/servers"
+)
+
+const overlayTestConfig = `- kind: [inet, http]
+  name: public
+  host: 0.0.0.0
+  port: 80
+- kind: [inet, grpc]
+  host: 0.0.0.0
+  port: 81
+- kind: [unix, http]
+  name: admin-sock
+  addr: /run/app/app.sock`
+
+func TestOverlay(t *testing.T) {
+	var ss servers.Servers
+
+	if err := yamlUnmarshal(overlayTestConfig, &ss); err != nil {
+		t.Fatal(err)
+	}
+
+	// index-keyed < name-keyed < flags
+	t.Setenv("APP_SERVERS_0_HOST", "10.0.0.1")
+	t.Setenv("APP_SERVERS_0_PORT", "8000")
+	t.Setenv("APP_SERVERS_PUBLIC_PORT", "8443")
+	t.Setenv("APP_SERVERS_PUBLIC_TLS_CERT_FILE", "/env/tls.crt")
+	t.Setenv("APP_SERVERS_PUBLIC_TLS_KEY_FILE", "/env/tls.key")
+	t.Setenv("APP_SERVERS_1_ADDR", "127.0.0.1:9000")
+	t.Setenv("APP_SERVERS_ADMIN_SOCK_ADDR", "/env/app.sock")
+
+	if err := ss.OverlayEnv("APP_SERVERS"); err != nil {
+		t.Fatalf("overlay env: %s", err)
+	}
+
+	var flags servers.Flags
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	flags.Register(fs, "")
+
+	if err := fs.Parse([]string{"-port", "9443", "-tls-key-file", "/flag/tls.key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ss.OverlayFlags(&flags); err != nil {
+		t.Fatalf("overlay flags: %s", err)
+	}
+
+	public := ss[0].Server.(*servers.ServerINET)
+	grpc := ss[1].Server.(*servers.ServerINET)
+	unix := ss[2].Server.(*servers.ServerUNIX)
+
+	for _, tt := range []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"index-keyed host", public.Host, "10.0.0.1"},
+		{"flag over name-keyed port", public.Port, 9443},
+		{"name-keyed cert-file", public.TLS.CertFile, "/env/tls.crt"},
+		{"flag over name-keyed key-file", public.TLS.KeyFile, "/flag/tls.key"},
+		{"index-keyed addr host", grpc.Host, "127.0.0.1"},
+		{"index-keyed addr port", grpc.Port, 9000},
+		{"name-keyed unix addr", unix.Address, "/env/app.sock"},
+	} {
+		if tt.got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, tt.got)
+		}
+	}
+}
+
+func TestOverlayErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config string
+		env    map[string]string
+		args   []string
+		err    error
+	}{
+		{"unknown field", overlayTestConfig, map[string]string{"APP_SERVERS_0_PROT": "1"}, nil, servers.ErrOverlayEnvUnknown},
+		{"unknown server", overlayTestConfig, map[string]string{"APP_SERVERS_3_PORT": "1"}, nil, servers.ErrOverlayEnvUnknown},
+		{"unix port", overlayTestConfig, map[string]string{"APP_SERVERS_ADMIN_SOCK_PORT": "1"}, nil, servers.ErrOverlayFieldNotApplicable},
+		{"invalid port", overlayTestConfig, map[string]string{"APP_SERVERS_PUBLIC_PORT": "https"}, nil, servers.ErrOverlayPortInvalid},
+		{"duplicate name", "- name: api\n- name: API", nil, nil, servers.ErrServerNameDuplicate},
+		{"invalid name", "- name: api.v2", nil, nil, servers.ErrServerNameInvalid},
+		{"name starting with digit", "- name: 1-public", nil, nil, servers.ErrServerNameInvalid},
+		{"flag of no server", "- kind: inet", nil, []string{"-addr", "/run/app.sock"}, servers.ErrOverlayNoServer},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var ss servers.Servers
+
+			if err := yamlUnmarshal(tt.config, &ss); err != nil {
+				t.Fatal(err)
+			}
+
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			var flags servers.Flags
+
+			fs := flag.NewFlagSet("app", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			flags.Register(fs, "")
+
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ss.OverlayEnv("APP_SERVERS")
+			if err == nil {
+				err = ss.OverlayFlags(&flags)
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestServerNameValidate(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		err    error
+	}{
+		{"- name: public-api_v2\n  host: 127.0.0.1", nil},
+		// checked by OverlayEnv only
+		{"- name: 1-public\n  host: 127.0.0.1", nil},
+		{"- name: api.v2\n  host: 127.0.0.1", nil},
+		{"- name: api\n  host: 127.0.0.1\n- name: API\n  host: 127.0.0.1", servers.ErrServerNameDuplicate},
+	} {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(tt.config, &ss); err != nil {
+			t.Fatal(err)
+		}
+
+		ss.Defaultize("127.0.0.1", 0, "")
+
+		if err := ss.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("%q: expected %v, got %v", tt.config, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package servers
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/go-x-pkg/log"
+)
+
+// net/http/pprof Index resolves named profiles relative to this path only.
+const pprofNativePrefix = "/debug/pprof"
+
+func newPprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(pprofNativePrefix+"/", pprof.Index)
+	mux.HandleFunc(pprofNativePrefix+"/cmdline", pprof.Cmdline)
+	mux.HandleFunc(pprofNativePrefix+"/profile", pprof.Profile)
+	mux.HandleFunc(pprofNativePrefix+"/symbol", pprof.Symbol)
+	mux.HandleFunc(pprofNativePrefix+"/trace", pprof.Trace)
+
+	return mux
+}
+
+// withPprof routes requests under prefix to pprof handlers
+// and everything else to next.
+// Prefix is rewritten to /debug/pprof so named profiles (heap, goroutine, ...) work
+// under any prefix.
+func withPprof(prefix string, next http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux := newPprofMux()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if path == prefix {
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = pprofNativePrefix + strings.TrimPrefix(path, prefix)
+		r2.URL.RawPath = ""
+
+		mux.ServeHTTP(w, r2)
+	})
+}
+
+// newPprofHandler mounts pprof on handler built by fnNewHandler
+// according to listener config.
+func newPprofHandler(s Server, fnNewHandler func(Server) http.Handler, cfg *args) http.Handler {
+	base := s.Base()
+
+	if !base.Pprof.Enable {
+		return fnNewHandler(s)
+	}
+
+	if cfg.pprofUNIXOnly && !s.Kind().Has(KindUNIX) {
+		cfg.fnLog(log.Warn, "%s pprof is enabled on %s but restricted to unix listeners, skip",
+			runLogPrefix(s), s.Addr())
+
+		if base.Pprof.Dedicated {
+			return http.NotFoundHandler()
+		}
+
+		return fnNewHandler(s)
+	}
+
+	cfg.fnLog(log.Info, "%s pprof mounted on %s%s", runLogPrefix(s), s.Addr(), base.Pprof.Prefix)
+
+	if base.Pprof.Dedicated {
+		return withPprof(base.Pprof.Prefix, http.NotFoundHandler())
+	}
+
+	return withPprof(base.Pprof.Prefix, fnNewHandler(s))
+}
@@ -0,0 +1,89 @@
+package servers_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-x-pkg/servers"
+)
+
+func TestPprof(t *testing.T) {
+	ss := newTestServers(t, `- kind: [inet, http]
+  host: 127.0.0.1
+  pprof:
+    enable: true
+    prefix: /_debug/pprof
+- kind: [inet, http]
+  host: 127.0.0.1
+  pprof:
+    enable: true
+    dedicated: true`)
+
+	listeners := listenTestServers(t, ss)
+	shared := listenerAddr(t, listeners, ss[0].Server)
+	dedicated := listenerAddr(t, listeners, ss[1].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(func(servers.Server) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "app")
+			})
+		}, servers.Context(ctx))
+	}()
+
+	defer func() { cancel(); <-done }()
+
+	get := func(addr, path string) (int, string) {
+		t.Helper()
+
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %s", path, err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get(shared, "/"); code != http.StatusOK || body != "app" {
+		t.Errorf("app handler: %d %q", code, body)
+	}
+
+	if code, body := get(shared, "/_debug/pprof/"); code != http.StatusOK ||
+		!strings.Contains(body, "/debug/pprof/") || !strings.Contains(body, "goroutine?debug=1") {
+		t.Errorf("pprof index under custom prefix: %d %q", code, body)
+	}
+
+	if code, body := get(shared, "/_debug/pprof/goroutine?debug=1"); code != http.StatusOK ||
+		!strings.HasPrefix(body, "goroutine profile: total ") {
+		t.Errorf("pprof under custom prefix: %d %q", code, body)
+	}
+
+	if code, body := get(shared, "/debug/pprof/"); code != http.StatusOK || body != "app" {
+		t.Errorf("default prefix must fall through to app handler: %d %q", code, body)
+	}
+
+	if code, body := get(dedicated, "/debug/pprof/cmdline"); code != http.StatusOK ||
+		!strings.Contains(body, filepath.Base(os.Args[0])) {
+		t.Errorf("dedicated pprof cmdline: %d %q", code, body)
+	}
+
+	if code, body := get(dedicated, "/debug/pprof/heap?debug=1"); code != http.StatusOK ||
+		!strings.HasPrefix(body, "heap profile: ") {
+		t.Errorf("dedicated pprof heap: %d %q", code, body)
+	}
+
+	if code, _ := get(dedicated, "/"); code != http.StatusNotFound {
+		t.Errorf("dedicated listener must not serve app handler: %d", code)
+	}
+}
@@ -0,0 +1,192 @@
+package servers
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-x-pkg/dumpctx"
+	proxyproto "github.com/pires/go-proxyproto"
+)
+
+const (
+	ProxyProtocolModeRequired = "required"
+	ProxyProtocolModeOptional = "optional"
+)
+
+// ProxyProtocolConfig accepts PROXY protocol (v1 and v2) header
+// sent by load balancer (HAProxy, AWS NLB, ...),
+// RemoteAddr of accepted connections is address of real client.
+type ProxyProtocolConfig struct {
+	Enable bool `json:"enable" yaml:"enable" bson:"enable"`
+	// Mode is "required" (connection without header is rejected)
+	// or "optional" (connection without header is served as is).
+	Mode string `json:"mode" yaml:"mode" bson:"mode"`
+	// TrustedCIDRs are sources header is accepted from (IP is single host CIDR),
+	// required to not let anyone forge RemoteAddr.
+	// Connections from other sources are closed in required mode;
+	// in optional mode they are served as is and rejected if they send header.
+	TrustedCIDRs []string `json:"trustedCIDRs" yaml:"trustedCIDRs" bson:"trustedCIDRs"`
+	// ReadHeaderTimeout bounds waiting for header.
+	ReadHeaderTimeout time.Duration `json:"readHeaderTimeout" yaml:"readHeaderTimeout" bson:"readHeaderTimeout"`
+}
+
+func (c *ProxyProtocolConfig) defaultize() {
+	if c.Mode == "" {
+		c.Mode = ProxyProtocolModeRequired
+	}
+
+	if c.ReadHeaderTimeout == 0 {
+		c.ReadHeaderTimeout = defaultProxyProtocolReadHeaderTimeout
+	}
+}
+
+func (c *ProxyProtocolConfig) interpolate(interpolateFn func(string) string) {
+	c.Mode = interpolateFn(c.Mode)
+	interpolateStrings(interpolateFn, c.TrustedCIDRs)
+}
+
+func (c *ProxyProtocolConfig) validate() error {
+	if !c.Enable {
+		return nil
+	}
+
+	if c.Mode != ProxyProtocolModeRequired && c.Mode != ProxyProtocolModeOptional {
+		return fmt.Errorf("(:mode %q): %w", c.Mode, ErrProxyProtocolModeInvalid)
+	}
+
+	if c.ReadHeaderTimeout < 0 {
+		return fmt.Errorf("(:readHeaderTimeout %s): %w", c.ReadHeaderTimeout, ErrProxyProtocolNegativeTimeout)
+	}
+
+	if len(c.TrustedCIDRs) == 0 {
+		return ErrProxyProtocolNoTrustedCIDRs
+	}
+
+	if _, err := c.trustedNets(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *ProxyProtocolConfig) trustedNets() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(c.TrustedCIDRs))
+
+	for _, v := range c.TrustedCIDRs {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("(:cidr %q): %w", v, ErrProxyProtocolCIDRInvalid)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("(:cidr %q): %s: %w", v, err, ErrProxyProtocolCIDRInvalid)
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+func isTrustedAddr(trusted []*net.IPNet, upstream net.Addr) bool {
+	addr, ok := upstream.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, n := range trusted {
+		if n.Contains(addr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// policy decides how connection from upstream is treated:
+// trusted one must (or may) send header, untrusted one must not.
+func (c *ProxyProtocolConfig) policy(trusted []*net.IPNet) proxyproto.PolicyFunc {
+	use := proxyproto.REQUIRE
+	if c.Mode == ProxyProtocolModeOptional {
+		use = proxyproto.USE
+	}
+
+	return func(upstream net.Addr) (proxyproto.Policy, error) {
+		if isTrustedAddr(trusted, upstream) {
+			return use, nil
+		}
+
+		return proxyproto.REJECT, nil
+	}
+}
+
+// trustedListener closes connections from untrusted sources on accept,
+// so in required mode no connection is served without header.
+type trustedListener struct {
+	net.Listener
+
+	trusted []*net.IPNet
+}
+
+func (l *trustedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if isTrustedAddr(l.trusted, conn.RemoteAddr()) {
+			return conn, nil
+		}
+
+		conn.Close()
+	}
+}
+
+// listener wraps listener to read PROXY header,
+// TLS (if any) is terminated on top of it.
+func (c *ProxyProtocolConfig) listener(listener net.Listener) net.Listener {
+	if !c.Enable {
+		return listener
+	}
+
+	// validated already
+	trusted, _ := c.trustedNets()
+
+	if c.Mode == ProxyProtocolModeRequired {
+		listener = &trustedListener{Listener: listener, trusted: trusted}
+	}
+
+	return &proxyproto.Listener{
+		Listener:          listener,
+		Policy:            c.policy(trusted),
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+	}
+}
+
+func (c *ProxyProtocolConfig) dump(ctx *dumpctx.Ctx, w io.Writer) {
+	fmt.Fprintf(w, "%senable: %t\n", ctx.Indent(), c.Enable)
+
+	if !c.Enable {
+		return
+	}
+
+	fmt.Fprintf(w, "%smode: %s\n", ctx.Indent(), c.Mode)
+	fmt.Fprintf(w, "%strustedCIDRs: [%s]\n", ctx.Indent(), strings.Join(c.TrustedCIDRs, ", "))
+	fmt.Fprintf(w, "%sreadHeaderTimeout: %s\n", ctx.Indent(), c.ReadHeaderTimeout)
+}
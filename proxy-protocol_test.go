@@ -0,0 +1,312 @@
+package servers_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+const testProxyHeader = "PROXY TCP4 203.0.113.7 127.0.0.1 5555 443\r\n"
+
+func serveRemoteAddr(servers.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.RemoteAddr) })
+}
+
+// proxiedGet sends PROXY header (if any) and GET over conn,
+// returns body of 200 response or error.
+func proxiedGet(conn net.Conn, header string) (string, error) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if header != "" {
+		if _, err := io.WriteString(conn, header); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"); err != nil {
+		return "", err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+
+	return string(body), err
+}
+
+func serveProxyProtocol(t *testing.T, yaml string, serve func(servers.Servers, context.Context) error) string {
+	t.Helper()
+
+	ss := newTestServers(t, yaml)
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() { done <- serve(listeners, ctx) }()
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Errorf("serve: %s", err)
+		}
+	})
+
+	return addr
+}
+
+func TestProxyProtocolHTTP(t *testing.T) {
+	addr := serveProxyProtocol(t, `- kind: [inet, http]
+  proxyProtocol:
+    enable: true
+    trustedCIDRs: [127.0.0.0/8]`, func(listeners servers.Servers, ctx context.Context) error {
+		return listeners.ServeHTTP(serveRemoteAddr, servers.Context(ctx))
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+
+	body, err := proxiedGet(conn, testProxyHeader)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+
+	if body != "203.0.113.7:5555" {
+		t.Errorf("unexpected remote addr %q", body)
+	}
+
+	// header is required
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+
+	if body, err := proxiedGet(conn, ""); err == nil {
+		t.Errorf("request without header served: %q", body)
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	addr := serveProxyProtocol(t, `- kind: [inet, http]
+  proxyProtocol:
+    enable: true
+    mode: optional
+    trustedCIDRs: [10.0.0.0/8]`, func(listeners servers.Servers, ctx context.Context) error {
+		return listeners.ServeHTTP(serveRemoteAddr, servers.Context(ctx))
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+
+	if body, err := proxiedGet(conn, testProxyHeader); err == nil {
+		t.Errorf("header from untrusted source accepted: %q", body)
+	}
+
+	// optional mode, no header
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+
+	body, err := proxiedGet(conn, "")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+
+	if host, _, _ := net.SplitHostPort(body); host != "127.0.0.1" {
+		t.Errorf("unexpected remote addr %q", body)
+	}
+}
+
+func TestProxyProtocolUntrustedRequired(t *testing.T) {
+	addr := serveProxyProtocol(t, `- kind: [inet, http]
+  proxyProtocol:
+    enable: true
+    trustedCIDRs: [10.0.0.0/8]`, func(listeners servers.Servers, ctx context.Context) error {
+		return listeners.ServeHTTP(serveRemoteAddr, servers.Context(ctx))
+	})
+
+	// connections of untrusted source are closed, with header or not
+	for _, header := range []string{testProxyHeader, ""} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		defer conn.Close()
+
+		if body, err := proxiedGet(conn, header); err == nil {
+			t.Errorf("untrusted source served (:header %q): %q", header, body)
+		}
+	}
+}
+
+func TestProxyProtocolTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	cert := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+
+	addr := serveProxyProtocol(t, fmt.Sprintf(`- kind: [inet, http]
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+  proxyProtocol:
+    enable: true
+    trustedCIDRs: [127.0.0.1]`, cert.certFile, cert.keyFile), func(listeners servers.Servers, ctx context.Context) error {
+		return listeners.ServeHTTP(serveRemoteAddr, servers.Context(ctx))
+	})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer raw.Close()
+
+	// PROXY header goes in plaintext before TLS handshake
+	if _, err := io.WriteString(raw, testProxyHeader); err != nil {
+		t.Fatalf("write header: %s", err)
+	}
+
+	conn := tls.Client(raw, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1", MinVersion: tls.VersionTLS12})
+
+	body, err := proxiedGet(conn, "")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+
+	if body != "203.0.113.7:5555" {
+		t.Errorf("unexpected remote addr %q", body)
+	}
+}
+
+func TestProxyProtocolGRPC(t *testing.T) {
+	peers := make(chan net.Addr, 1)
+
+	addr := serveProxyProtocol(t, `- kind: [inet, grpc]
+  health:
+    enable: true
+  proxyProtocol:
+    enable: true
+    trustedCIDRs: [127.0.0.1]`, func(listeners servers.Servers, ctx context.Context) error {
+		return listeners.ServeGRPC(func(_ servers.Server, opts ...grpc.ServerOption) *grpc.Server {
+			opts = append(opts, grpc.UnaryInterceptor(func(
+				ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+			) (interface{}, error) {
+				if p, ok := peer.FromContext(ctx); ok {
+					peers <- p.Addr
+				}
+
+				return handler(ctx, req)
+			}))
+
+			return grpc.NewServer(opts...)
+		}, servers.Context(ctx))
+	})
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := io.WriteString(conn, testProxyHeader); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(dialer),
+	)
+	if err != nil {
+		t.Fatalf("grpc dial: %s", err)
+	}
+	defer conn.Close()
+
+	ctxTimeout, cancelTimeout := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelTimeout()
+
+	if _, err := healthpb.NewHealthClient(conn).Check(ctxTimeout, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("grpc health check: %s", err)
+	}
+
+	if got := (<-peers).String(); got != "203.0.113.7:5555" {
+		t.Errorf("unexpected peer %q", got)
+	}
+}
+
+func TestProxyProtocolValidate(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		err    error
+	}{
+		{`- kind: [inet, http]
+  proxyProtocol:
+    enable: true
+    mode: sometimes
+    trustedCIDRs: [10.0.0.0/8]`, servers.ErrProxyProtocolModeInvalid},
+		{`- kind: [inet, http]
+  proxyProtocol:
+    enable: true
+    trustedCIDRs: [10.0.0.0/33]`, servers.ErrProxyProtocolCIDRInvalid},
+		// trusting everyone must be explicit
+		{`- kind: [inet, http]
+  proxyProtocol:
+    enable: true`, servers.ErrProxyProtocolNoTrustedCIDRs},
+		{`- kind: [inet, http]
+  proxyProtocol:
+    enable: true
+    trustedCIDRs: [0.0.0.0/0, "::/0"]`, nil},
+	} {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(tt.config, &ss); err != nil {
+			t.Fatal(err)
+		}
+
+		ss.Defaultize("127.0.0.1", 0, "")
+
+		if err := ss.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("%q: expected %v, got %v", tt.config, tt.err, err)
+		}
+	}
+}
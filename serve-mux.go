@@ -0,0 +1,117 @@
+package servers
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	xlog "github.com/go-x-pkg/log"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+const grpcContentType = "application/grpc"
+
+func isGRPCRequest(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType)
+}
+
+// newMuxHandler routes HTTP/2 gRPC requests to gRPC server
+// and everything else to http handler.
+func newMuxHandler(server *grpc.Server, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isGRPCRequest(r) {
+			server.ServeHTTP(w, r)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// ServeMux serves HTTP and gRPC on the same listener.
+// Requests are routed by content-type: application/grpc over HTTP/2 goes
+// to gRPC server, the rest goes to http handler.
+// TLS listeners negotiate h2 through ALPN, plaintext ones accept h2c.
+//
+// TLS is terminated by http.Server, so fnNewServer
+// gets no transport credentials in opts.
+func (it iterator) ServeMux(
+	fnNewHandler func(Server) http.Handler,
+	fnNewServer func(s Server, opts ...grpc.ServerOption) *grpc.Server,
+	fnArgs ...Arg,
+) error {
+	cfg := newArgs(fnArgs...)
+
+	return it.serveEach(cfg, func(ctx context.Context, l *ServerListener) error {
+		return serveMux(ctx, cfg, l, fnNewHandler, fnNewServer)
+	})
+}
+
+// serveMux serves HTTP+gRPC listener until ctx is done.
+func serveMux(
+	ctx context.Context, cfg *args, l *ServerListener,
+	fnNewHandler func(Server) http.Handler,
+	fnNewServer func(s Server, opts ...grpc.ServerOption) *grpc.Server,
+) error {
+	fnLog := cfg.fnLog
+	addr := l.Addr()
+
+	fnLog(xlog.Info, "%s HTTP+gRPC server starting on %s", runLogPrefix(l), addr)
+
+	// transport options (keepalive, windows, streams) have no effect
+	// with ServeHTTP transport, http2 settings of http config apply instead
+	opts := append(l.Base().GRPC.serverOptions(), cfg.metrics.serverOptions(l.Server)...)
+	grpcServer := fnNewServer(l.Server, opts...)
+
+	if l.Base().GRPC.Reflection {
+		reflection.Register(grpcServer)
+	}
+
+	if l.Base().Health.Enable {
+		healthpb.RegisterHealthServer(grpcServer, cfg.health.GRPC())
+	}
+
+	handler := newMuxHandler(grpcServer, newHTTPHandler(l.Server, fnNewHandler, cfg))
+	// http.Server.Shutdown drains gRPC streams on TLS connections,
+	// but doesn't track hijacked h2c ones, so close what's left afterwards.
+	// GracefulStop is not supported with ServeHTTP transport.
+	server, wait := newHTTPServer(ctx, cfg, l, handler, grpcServer.Stop)
+
+	var tlsConfig *tls.Config
+
+	if inet, ok := l.Server.(*ServerINET); ok {
+		var err error
+
+		if tlsConfig, err = inet.newTLSConfig(ctx, fnLog); err != nil {
+			return newListenerError(l, PhaseTLSLoad, err)
+		}
+
+		// gRPC stream context is derived from request one
+		if tlsConfig != nil && inet.ClientAuth.TLS.Enable {
+			applyClientIdentity(server)
+		}
+	}
+
+	if tlsConfig != nil {
+		server.TLSConfig = tlsConfig
+	}
+
+	h2s, err := l.Base().HTTP.configureHTTP2(server)
+	if err != nil {
+		return err
+	}
+
+	listener := newServeListener(cfg, l)
+
+	if tlsConfig != nil {
+		listener = cfg.metrics.tlsListener(l.Server, listener, server)
+	} else {
+		server.Handler = h2c.NewHandler(handler, h2s)
+	}
+
+	return serveHTTPServer(server, listener, wait)
+}
@@ -0,0 +1,112 @@
+package servers_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestServeMux(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	srv := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http, grpc]
+  host: 127.0.0.1
+- kind: [inet, http, grpc]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q`, srv.certFile, srv.keyFile))
+
+	listeners := listenTestServers(t, ss)
+	h2cAddr := listenerAddr(t, listeners, ss[0].Server)
+	tlsAddr := listenerAddr(t, listeners, ss[1].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeMux(
+			func(servers.Server) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					io.WriteString(w, "app")
+				})
+			},
+			func(_ servers.Server, opts ...grpc.ServerOption) *grpc.Server {
+				server := grpc.NewServer(opts...)
+				healthpb.RegisterHealthServer(server, health.NewServer())
+
+				return server
+			},
+			servers.Context(ctx),
+		)
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	tlsConfig := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13}
+
+	tests := []struct {
+		name       string
+		addr       string
+		httpClient *http.Client
+		scheme     string
+		creds      credentials.TransportCredentials
+	}{
+		{"h2c", h2cAddr, http.DefaultClient, "http", insecure.NewCredentials()},
+		{"tls", tlsAddr, &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true},
+		}, "https", credentials.NewTLS(tlsConfig)},
+	}
+
+	for _, tt := range tests {
+		resp, err := tt.httpClient.Get(tt.scheme + "://" + tt.addr + "/")
+		if err != nil {
+			t.Fatalf("%s: http: %s", tt.name, err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != "app" {
+			t.Errorf("%s: unexpected http body %q", tt.name, body)
+		}
+
+		conn, err := grpc.Dial(tt.addr, grpc.WithTransportCredentials(tt.creds))
+		if err != nil {
+			t.Fatalf("%s: dial: %s", tt.name, err)
+		}
+
+		ctxTimeout, cancelTimeout := context.WithTimeout(ctx, 5*time.Second)
+
+		if _, err := healthpb.NewHealthClient(conn).Check(ctxTimeout, &healthpb.HealthCheckRequest{}); err != nil {
+			t.Errorf("%s: grpc health check: %s", tt.name, err)
+		}
+
+		cancelTimeout()
+		conn.Close()
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Errorf("serve: %s", err)
+	}
+}
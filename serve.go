@@ -0,0 +1,43 @@
+package servers
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+)
+
+// Serve serves every listener by its kind under one lifecycle:
+// [http] with handler, [grpc] with gRPC server and [http, grpc] with both (see ServeMux).
+// Factory may be nil if there are no listeners of its kind.
+//
+// If any listener fails, all of them are shutdown
+// within shutdown timeout and returned error names failed listeners.
+func (it iterator) Serve(
+	fnNewHandler func(Server) http.Handler,
+	fnNewServer func(s Server, opts ...grpc.ServerOption) *grpc.Server,
+	fnArgs ...Arg,
+) error {
+	it = it.FilterListener()
+
+	if fnNewHandler == nil && it.FilterHTTP().Len() != 0 {
+		return ErrServeNoHTTPHandler
+	}
+
+	if fnNewServer == nil && it.FilterGRPC().Len() != 0 {
+		return ErrServeNoGRPCServer
+	}
+
+	cfg := newArgs(fnArgs...)
+
+	return it.serveEach(cfg, func(ctx context.Context, l *ServerListener) error {
+		switch {
+		case takeMux(l):
+			return serveMux(ctx, cfg, l, fnNewHandler, fnNewServer)
+		case takeGRPC(l):
+			return serveGRPC(ctx, cfg, l, fnNewServer)
+		default:
+			return serveHTTP(ctx, cfg, l, fnNewHandler)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package servers_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func newTestGRPCServer(_ servers.Server, opts ...grpc.ServerOption) *grpc.Server {
+	return grpc.NewServer(opts...)
+}
+
+func TestServe(t *testing.T) {
+	ss := newTestServers(t, `- kind: [inet, http]
+  host: 127.0.0.1
+- kind: [inet, grpc]
+  host: 127.0.0.1
+  health:
+    enable: true`)
+
+	listeners := listenTestServers(t, ss)
+	httpAddr := listenerAddr(t, listeners, ss[0].Server)
+	grpcAddr := listenerAddr(t, listeners, ss[1].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.Serve(serveOneBody("app"), newTestGRPCServer, servers.Context(ctx))
+	}()
+
+	resp, err := http.Get("http://" + httpAddr)
+	if err != nil {
+		t.Fatalf("GET: %s", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "app" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc dial: %s", err)
+	}
+	defer conn.Close()
+
+	ctxTimeout, cancelTimeout := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelTimeout()
+
+	if _, err := healthpb.NewHealthClient(conn).Check(ctxTimeout, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("grpc health check: %s", err)
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+}
+
+func TestServeListenerFailure(t *testing.T) {
+	ss := newTestServers(t, `- kind: [inet, http]
+  host: 127.0.0.1
+- kind: [inet, grpc]
+  host: 127.0.0.1`)
+
+	listeners := listenTestServers(t, ss)
+	httpAddr := listenerAddr(t, listeners, ss[0].Server)
+	grpcAddr := listenerAddr(t, listeners, ss[1].Server)
+
+	// gRPC listener fails right away
+	for _, l := range listeners {
+		if sl := l.Server.(*servers.ServerListener); sl.Server == ss[1].Server {
+			sl.Listener.Close()
+		}
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.Serve(
+			serveOneBody("app"), newTestGRPCServer,
+			servers.FnShutdownTimeout(func() time.Duration { return 5 * time.Second }),
+		)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		var le *servers.ListenerError
+		if !errors.As(err, &le) || !le.Kind.Has(servers.KindGRPC) || le.Phase != servers.PhaseServe {
+			t.Errorf("error doesn't name failed listener: %s", err)
+		}
+
+		if !strings.Contains(err.Error(), grpcAddr) {
+			t.Errorf("error doesn't name failed listener %s: %s", grpcAddr, err)
+		}
+
+		if strings.Contains(err.Error(), httpAddr) {
+			t.Errorf("error names healthy listener %s: %s", httpAddr, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("serve didn't return after listener failure")
+	}
+}
+
+func TestServeNoFactory(t *testing.T) {
+	listeners := listenTestServers(t, newTestServers(t, `- kind: [inet, grpc]`))
+
+	if err := listeners.Serve(serveOneBody("app"), nil); err != servers.ErrServeNoGRPCServer {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -3,7 +3,7 @@ package servers
 import (
 	"fmt"
 	"io"
-	"time"
+	"strings"
 
 	"github.com/go-x-pkg/dumpctx"
 )
@@ -41,17 +41,28 @@ type ServerBase struct {
 	WithKind    `json:",inline" yaml:",inline" bson:",inline"`
 	WithNetwork `json:",inline" yaml:",inline" bson:",inline"`
 
-	GRPC struct {
-		Reflection bool `yaml:"reflection"`
-	} `yaml:"grpc"`
+	GRPC GRPCConfig `yaml:"grpc"`
 
-	HTTP struct {
-		ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
-	} `yaml:"http"`
+	HTTP HTTPConfig `yaml:"http"`
+
+	Health HealthConfig `yaml:"health"`
+
+	Metrics MetricsConfig `yaml:"metrics"`
+
+	// Name keys server in environment overlay (OverlayEnv),
+	// there it must be letters, digits, '-' and '_', starting with letter.
+	Name string `yaml:"name"`
+
+	// FDName matches listener inherited through systemd socket activation
+	// (FileDescriptorName= of .socket unit). If empty, matched by address.
+	FDName string `yaml:"fdName"`
 
 	Pprof struct {
 		Enable bool   `yaml:"enable"`
 		Prefix string `yaml:"prefix"`
+		// Dedicated listener serves pprof handlers only,
+		// handler given to ServeHTTP is not mounted.
+		Dedicated bool `yaml:"dedicated"`
 	} `yaml:"pprof"`
 }
 
@@ -68,7 +79,47 @@ func (s *ServerBase) Network() string {
 }
 
 func (s *ServerBase) validate() error {
-	return s.WithKind.validate()
+	if err := s.WithKind.validate(); err != nil {
+		return err
+	}
+
+	if s.Pprof.Enable {
+		if !strings.HasPrefix(s.Pprof.Prefix, "/") {
+			return fmt.Errorf("(:prefix %q): %w", s.Pprof.Prefix, ErrPprofPrefixInvalid)
+		}
+
+		if !s.Kind().Has(KindHTTP) {
+			return ErrPprofNoHTTP
+		}
+	}
+
+	if s.Kind().Has(KindHTTP) {
+		if err := s.HTTP.validate(); err != nil {
+			return err
+		}
+	}
+
+	if s.Kind().Has(KindGRPC) {
+		if err := s.GRPC.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Health.validate(); err != nil {
+		return err
+	}
+
+	if s.Metrics.Enable {
+		if !strings.HasPrefix(s.Metrics.Path, "/") {
+			return fmt.Errorf("(:path %q): %w", s.Metrics.Path, ErrMetricsPathInvalid)
+		}
+
+		if !s.Kind().Has(KindHTTP) {
+			return ErrMetricsNoHTTP
+		}
+	}
+
+	return nil
 }
 
 func (s *ServerBase) defaultize() error {
@@ -80,25 +131,63 @@ func (s *ServerBase) defaultize() error {
 		s.Pprof.Prefix = defaultPprofPrefix
 	}
 
-	if s.HTTP.ReadHeaderTimeout == 0 {
-		s.HTTP.ReadHeaderTimeout = defaultReadHeaderTimeout
+	s.HTTP.defaultize()
+	s.Health.defaultize()
+
+	if s.Metrics.Path == "" {
+		s.Metrics.Path = defaultMetricsPath
 	}
 
 	return nil
 }
 
+func (s *ServerBase) interpolate(interpolateFn func(string) string) {
+	s.Net = interpolateFn(s.Net)
+	s.Name = interpolateFn(s.Name)
+	s.FDName = interpolateFn(s.FDName)
+	s.Pprof.Prefix = interpolateFn(s.Pprof.Prefix)
+	s.Metrics.Path = interpolateFn(s.Metrics.Path)
+	s.Health.interpolate(interpolateFn)
+}
+
 func (s *ServerBase) Dump(ctx *dumpctx.Ctx, w io.Writer) {
+	if s.Name != "" {
+		fmt.Fprintf(w, "%sname: %s\n", ctx.Indent(), s.Name)
+	}
+
+	if s.FDName != "" {
+		fmt.Fprintf(w, "%sfdName: %s\n", ctx.Indent(), s.FDName)
+	}
+
 	if s.Kind().Has(KindGRPC) {
 		fmt.Fprintf(w, "%sgrpc:\n", ctx.Indent())
 		ctx.Wrap(func() {
-			fmt.Fprintf(w, "%sreflection: %t\n", ctx.Indent(), s.GRPC.Reflection)
+			s.GRPC.dump(ctx, w)
 		})
 	}
 
 	if s.Kind().Has(KindHTTP) {
 		fmt.Fprintf(w, "%shttp:\n", ctx.Indent())
 		ctx.Wrap(func() {
-			fmt.Fprintf(w, "%sreadHeaderTimeout: %s\n", ctx.Indent(), s.HTTP.ReadHeaderTimeout)
+			s.HTTP.dump(ctx, w)
+		})
+	}
+
+	fmt.Fprintf(w, "%shealth:\n", ctx.Indent())
+	ctx.Wrap(func() {
+		fmt.Fprintf(w, "%senable: %t\n", ctx.Indent(), s.Health.Enable)
+
+		if s.Kind().Has(KindHTTP) {
+			fmt.Fprintf(w, "%slivenessPath: %q\n", ctx.Indent(), s.Health.LivenessPath)
+			fmt.Fprintf(w, "%sreadinessPath: %q\n", ctx.Indent(), s.Health.ReadinessPath)
+		}
+	})
+
+	if s.Kind().Has(KindHTTP) {
+		fmt.Fprintf(w, "%smetrics:\n", ctx.Indent())
+		ctx.Wrap(func() {
+			fmt.Fprintf(w, "%senable: %t\n", ctx.Indent(), s.Metrics.Enable)
+			fmt.Fprintf(w, "%spath: %q\n", ctx.Indent(), s.Metrics.Path)
 		})
 	}
 
@@ -106,5 +195,6 @@ func (s *ServerBase) Dump(ctx *dumpctx.Ctx, w io.Writer) {
 	ctx.Wrap(func() {
 		fmt.Fprintf(w, "%senable: %t\n", ctx.Indent(), s.Pprof.Enable)
 		fmt.Fprintf(w, "%sprefix: %q\n", ctx.Indent(), s.Pprof.Prefix)
+		fmt.Fprintf(w, "%sdedicated: %t\n", ctx.Indent(), s.Pprof.Dedicated)
 	})
 }
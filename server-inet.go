@@ -1,6 +1,7 @@
 package servers
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -8,9 +9,13 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-x-pkg/dumpctx"
-	"github.com/go-x-pkg/fnspath"
+	"github.com/go-x-pkg/log"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type ServerINET struct {
@@ -26,11 +31,82 @@ type ServerINET struct {
 		MinVersion               versionTLS `yaml:"minVersion"`
 		MaxVersion               versionTLS `yaml:"maxVersion"`
 		PreferServerCipherSuites *bool      `yaml:"preferServerCipherSuites"`
+
+		// Certificates are served in addition to certFile/keyFile
+		// (which is default one unless other is set), selected by SNI.
+		Certificates []TLSCertificateConfig `yaml:"certificates"`
+		// ExpiryWarning is logged when served certificate expires within it.
+		// Defaults to 30 days, 0 disables warning.
+		ExpiryWarning *time.Duration `yaml:"expiryWarning"`
+
+		// Preset is modern, intermediate or old (Mozilla guidelines).
+		// It defaults minVersion, cipherSuites and curvePreferences.
+		Preset string `yaml:"preset"`
+		// CipherSuites are IANA names, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256.
+		// TLS 1.3 suites are not configurable. HTTP/2 requires an
+		// ECDHE AES_128_GCM_SHA256 suite when TLS 1.2 is allowed.
+		CipherSuites []string `yaml:"cipherSuites"`
+		// CurvePreferences are X25519, P-256, P-384, P-521.
+		CurvePreferences []string `yaml:"curvePreferences"`
+		// NextProtos are ALPN protocols offered after ones of server (h2, http/1.1).
+		NextProtos             []string `yaml:"nextProtos"`
+		SessionTicketsDisabled bool     `yaml:"sessionTicketsDisabled"`
+
+		ACME ACMEConfig `yaml:"acme"`
+
+		OCSPStapling OCSPStaplingConfig `yaml:"ocspStapling"`
+
+		// Reload certFile, keyFile and clientAuth caCertFile, crlFiles
+		// without restarting listeners.
+		Reload struct {
+			Enable bool `yaml:"enable"`
+			// Files are checked for changes every interval.
+			Interval time.Duration `yaml:"interval"`
+			// Reload on SIGHUP. Defaults to true.
+			SIGHUP *bool `yaml:"sighup"`
+		} `yaml:"reload"`
 	} `yaml:"tls"`
 
 	ClientAuth struct {
 		TLS ClientAuthTLSConfig `yaml:"tls"`
 	} `yaml:"clientAuth"`
+
+	ProxyProtocol ProxyProtocolConfig `yaml:"proxyProtocol"`
+
+	// ACMEHTTP01 serves ACME http-01 challenges of TLS listeners
+	// (served in the same Serve* call) on this plain http listener.
+	ACMEHTTP01 bool `yaml:"acmeHTTP01"`
+
+	rt *serverINETRuntime
+}
+
+// serverINETRuntime is state of served ServerINET, held by pointer
+// so config itself stays plain value.
+type serverINETRuntime struct {
+	mu sync.Mutex
+
+	// certSource is built once and shared by all Serve calls,
+	// its watch and staple goroutines run while any of them does.
+	certSource       *CertSource
+	certSourceRefs   int
+	certSourceCancel context.CancelFunc
+
+	acme *autocert.Manager
+}
+
+// serverINETRuntimeMu guards creation of runtime of any ServerINET.
+var serverINETRuntimeMu sync.Mutex
+
+// runtime returns (creating once) runtime state of s.
+func (s *ServerINET) runtime() *serverINETRuntime {
+	serverINETRuntimeMu.Lock()
+	defer serverINETRuntimeMu.Unlock()
+
+	if s.rt == nil {
+		s.rt = &serverINETRuntime{}
+	}
+
+	return s.rt
 }
 
 func (s *ServerINET) tlsPreferServerCipherSuites() bool {
@@ -41,6 +117,33 @@ func (s *ServerINET) tlsPreferServerCipherSuites() bool {
 	return *s.TLS.PreferServerCipherSuites
 }
 
+func (s *ServerINET) tlsReloadSIGHUP() bool {
+	if s.TLS.Reload.SIGHUP == nil {
+		return defaultTLSReloadSIGHUP
+	}
+
+	return *s.TLS.Reload.SIGHUP
+}
+
+func (s *ServerINET) tlsExpiryWarning() time.Duration {
+	if s.TLS.ExpiryWarning == nil {
+		return defaultTLSExpiryWarning
+	}
+
+	return *s.TLS.ExpiryWarning
+}
+
+// CertSource returns source of served certificate.
+// It's nil until TLS listener is served.
+func (s *ServerINET) CertSource() *CertSource {
+	rt := s.runtime()
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return rt.certSource
+}
+
 func (s *ServerINET) Base() *ServerBase { return &s.ServerBase }
 
 func (s *ServerINET) setPort(v int) { s.Port = v }
@@ -53,7 +156,9 @@ func (s *ServerINET) ClientAuthTLS() *ClientAuthTLSConfig {
 	return &s.ClientAuth.TLS
 }
 
-func (s *ServerINET) newTLSConfig() (*tls.Config, error) {
+// newTLSConfig builds tls.Config backed by CertSource.
+// With reload enabled source is watched until ctx is done.
+func (s *ServerINET) newTLSConfig(ctx context.Context, fnLog log.FnT) (*tls.Config, error) {
 	if !s.TLS.Enable && !s.ClientAuth.TLS.Enable {
 		return nil, nil
 	}
@@ -66,41 +171,118 @@ func (s *ServerINET) newTLSConfig() (*tls.Config, error) {
 		PreferServerCipherSuites: s.tlsPreferServerCipherSuites(),
 	}
 
-	if s.TLS.Enable {
-		cert, err := tls.LoadX509KeyPair(s.TLS.CertFile, s.TLS.KeyFile)
+	if err := s.applyTLSSettings(tlsConfig); err != nil {
+		return nil, err
+	}
+
+	if s.ClientAuth.TLS.Enable {
+		s.ClientAuth.TLS.apply(tlsConfig, fnLog)
+	}
+
+	rt := s.runtime()
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.certSource == nil {
+		var (
+			certs      []TLSCertificateConfig
+			caCertFile string
+			crlFiles   []string
+		)
+
+		if s.TLS.Enable && !s.TLS.ACME.Enable {
+			certs = s.tlsCertificates()
+		}
+
+		if s.ClientAuth.TLS.Enable {
+			caCertFile, crlFiles = s.ClientAuth.TLS.CACertFile, s.ClientAuth.TLS.CRLFiles
+		}
+
+		cs, err := newCertSource(certs, caCertFile, crlFiles, s.tlsExpiryWarning(), fnLog)
 		if err != nil {
-			return nil, fmt.Errorf("error load x509 key pair (:cert %q :key %q): %w",
-				s.TLS.CertFile, s.TLS.KeyFile, err)
+			return nil, err
 		}
 
-		tlsConfig.Certificates = []tls.Certificate{cert}
+		if s.TLS.Enable && !s.TLS.ACME.Enable && s.TLS.OCSPStapling.Enable {
+			cs.stapleFirst(ctx, s.TLS.OCSPStapling)
+		}
+
+		rt.certSource = cs
 	}
 
-	if s.ClientAuth.TLS.Enable {
-		tlsConfig.ClientAuth = s.ClientAuth.TLS.AuthType.orDefault().CryptoTLSClientAuthType()
+	rt.certSource.apply(tlsConfig)
 
-		if s.ClientAuth.TLS.CACertFile != "" {
-			caCertPool, err := loadCACertPool(
-				s.ClientAuth.TLS.CACertFile)
-			if err != nil {
-				return nil, err
-			}
+	if s.ClientAuth.TLS.Enable {
+		s.ClientAuth.TLS.applyRevocation(tlsConfig, rt.certSource, fnLog)
+	}
 
-			tlsConfig.ClientCAs = caCertPool
+	if s.TLS.Enable && s.TLS.ACME.Enable {
+		m, err := s.acmeManagerLocked(rt)
+		if err != nil {
+			return nil, err
 		}
+
+		applyACME(tlsConfig, m)
 	}
 
+	s.runCertSource(ctx, rt)
+
 	return tlsConfig, nil
 }
 
-func (s *ServerINET) interpolate(interpolateFn func(string) string) {
-	if interpolateFn == nil {
-		return
+// runCertSource starts watch and staple goroutines of cert source
+// on first of concurrent Serve calls and stops them when ctx of last one is done.
+// Must be called with rt.mu held.
+func (s *ServerINET) runCertSource(ctx context.Context, rt *serverINETRuntime) {
+	rt.certSourceRefs++
+
+	if rt.certSourceRefs == 1 {
+		runCtx, cancel := context.WithCancel(context.Background())
+		rt.certSourceCancel = cancel
+
+		if s.TLS.Reload.Enable {
+			go rt.certSource.watch(runCtx, s.TLS.Reload.Interval, s.tlsReloadSIGHUP())
+		}
+
+		if s.TLS.Enable && !s.TLS.ACME.Enable && s.TLS.OCSPStapling.Enable {
+			go rt.certSource.staple(runCtx, s.TLS.OCSPStapling)
+		}
 	}
 
+	go func() {
+		<-ctx.Done()
+
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+
+		if rt.certSourceRefs--; rt.certSourceRefs == 0 {
+			rt.certSourceCancel()
+		}
+	}()
+}
+
+func (s *ServerINET) interpolate(interpolateFn func(string) string) {
+	s.ServerBase.interpolate(interpolateFn)
+
+	s.Host = interpolateFn(s.Host)
+
 	s.TLS.CertFile = interpolateFn(s.TLS.CertFile)
 	s.TLS.KeyFile = interpolateFn(s.TLS.KeyFile)
-	s.ClientAuth.TLS.CACertFile = interpolateFn(s.ClientAuth.TLS.CACertFile)
+
+	for i := range s.TLS.Certificates {
+		s.TLS.Certificates[i].interpolate(interpolateFn)
+	}
+
+	s.TLS.Preset = interpolateFn(s.TLS.Preset)
+	interpolateStrings(interpolateFn, s.TLS.CipherSuites)
+	interpolateStrings(interpolateFn, s.TLS.CurvePreferences)
+	interpolateStrings(interpolateFn, s.TLS.NextProtos)
+
+	s.TLS.ACME.interpolate(interpolateFn)
+	s.TLS.OCSPStapling.interpolate(interpolateFn)
+	s.ClientAuth.TLS.interpolate(interpolateFn)
+	s.ProxyProtocol.interpolate(interpolateFn)
 }
 
 func (s *ServerINET) validate() error {
@@ -112,25 +294,47 @@ func (s *ServerINET) validate() error {
 		return ErrInvalidTLSConfigSet
 	}
 
-	if s.TLS.Enable {
-		if v := s.TLS.CertFile; v != "" {
-			if exists, err := fnspath.IsExists(v); err != nil {
-				return fmt.Errorf("tls cert-file existence check failed: %w", err)
-			} else if !exists {
-				return fmt.Errorf("error (:path %q): %w", v, ErrTLSCertFileNotExists)
-			}
-		} else {
-			return ErrTLSCertFilePathNotProvided
+	if err := s.ClientAuth.TLS.validate(); err != nil {
+		return err
+	}
+
+	if err := s.validateTLSSettings(); err != nil {
+		return err
+	}
+
+	if err := s.TLS.OCSPStapling.validate(); err != nil {
+		return err
+	}
+
+	if err := s.ProxyProtocol.validate(); err != nil {
+		return err
+	}
+
+	if s.TLS.ACME.Enable {
+		if !s.TLS.Enable {
+			return ErrACMETLSDisabled
 		}
 
-		if v := s.TLS.KeyFile; v != "" {
-			if exists, err := fnspath.IsExists(v); err != nil {
-				return fmt.Errorf("tls key-file existence check failed: %w", err)
-			} else if !exists {
-				return fmt.Errorf("error (:path %q): %w", v, ErrTLSKeyFileNotExists)
-			}
-		} else {
-			return ErrTLSKeyFilePathNotProvided
+		if s.TLS.CertFile != "" || s.TLS.KeyFile != "" || len(s.TLS.Certificates) != 0 {
+			return ErrACMEWithCertFile
+		}
+
+		if s.TLS.OCSPStapling.Enable {
+			return ErrOCSPStaplingACME
+		}
+
+		if err := s.TLS.ACME.validate(); err != nil {
+			return err
+		}
+	}
+
+	if s.ACMEHTTP01 && (s.TLS.Enable || !s.Kind().Has(KindHTTP)) {
+		return ErrACMEHTTP01NotPlainHTTP
+	}
+
+	if s.TLS.Enable && !s.TLS.ACME.Enable {
+		if err := s.validateTLSCertificates(); err != nil {
+			return err
 		}
 	}
 
@@ -144,13 +348,24 @@ func (s *ServerINET) defaultize() error {
 
 	if s.TLS.MinVersion == versionTLSUnknown {
 		s.TLS.MinVersion = defaultVersionTLS
+
+		if preset, ok := tlsPresets[s.TLS.Preset]; ok {
+			s.TLS.MinVersion = preset.minVersion
+		}
 	}
 
 	if s.TLS.MaxVersion == versionTLSUnknown {
 		s.TLS.MaxVersion = defaultVersionTLS
 	}
 
+	if s.TLS.Reload.Enable && s.TLS.Reload.Interval == 0 {
+		s.TLS.Reload.Interval = defaultTLSReloadInterval
+	}
+
+	s.TLS.ACME.defaultize()
+	s.TLS.OCSPStapling.defaultize()
 	s.ClientAuth.TLS.defaultize()
+	s.ProxyProtocol.defaultize()
 
 	return nil
 }
@@ -164,10 +379,59 @@ func (s *ServerINET) Dump(ctx *dumpctx.Ctx, w io.Writer) {
 		fmt.Fprintf(w, "%senable: %t\n", ctx.Indent(), s.TLS.Enable)
 		fmt.Fprintf(w, "%scertFile: %s\n", ctx.Indent(), s.TLS.CertFile)
 		fmt.Fprintf(w, "%skeyFile: %s\n", ctx.Indent(), s.TLS.KeyFile)
+
+		if s.TLS.CertFile != "" {
+			dumpCertFile(ctx, w, s.TLS.CertFile)
+		}
+
+		fmt.Fprintf(w, "%sexpiryWarning: %s\n", ctx.Indent(), s.tlsExpiryWarning())
+
+		if ln := len(s.TLS.Certificates); ln != 0 {
+			fmt.Fprintf(w, "%scertificates (x%d):\n", ctx.Indent(), ln)
+
+			for i := range s.TLS.Certificates {
+				ctx.WrapList(func() { s.TLS.Certificates[i].dump(ctx, w) })
+			}
+		}
+
 		fmt.Fprintf(w, "%sminVersion: %s\n", ctx.Indent(), s.TLS.MinVersion.orDefault())
 		fmt.Fprintf(w, "%smaxVersion: %s\n", ctx.Indent(), s.TLS.MaxVersion.orDefault())
 		fmt.Fprintf(w, "%spreferServerCipherSuites: %t\n", ctx.Indent(), s.tlsPreferServerCipherSuites())
 
+		if s.TLS.Preset != "" {
+			fmt.Fprintf(w, "%spreset: %s\n", ctx.Indent(), s.TLS.Preset)
+		}
+
+		fmt.Fprintf(w, "%scipherSuites: [%s]\n", ctx.Indent(), strings.Join(s.tlsCipherSuites(), ", "))
+		fmt.Fprintf(w, "%scurvePreferences: [%s]\n", ctx.Indent(), strings.Join(s.tlsCurvePreferences(), ", "))
+		fmt.Fprintf(w, "%snextProtos: [%s]\n", ctx.Indent(), strings.Join(s.TLS.NextProtos, ", "))
+		fmt.Fprintf(w, "%ssessionTicketsDisabled: %t\n", ctx.Indent(), s.TLS.SessionTicketsDisabled)
+
+		fmt.Fprintf(w, "%sacme:\n", ctx.Indent())
+		ctx.Wrap(func() {
+			s.TLS.ACME.dump(ctx, w)
+		})
+
+		fmt.Fprintf(w, "%sreload:\n", ctx.Indent())
+		ctx.Wrap(func() {
+			fmt.Fprintf(w, "%senable: %t\n", ctx.Indent(), s.TLS.Reload.Enable)
+			fmt.Fprintf(w, "%sinterval: %s\n", ctx.Indent(), s.TLS.Reload.Interval)
+			fmt.Fprintf(w, "%ssighup: %t\n", ctx.Indent(), s.tlsReloadSIGHUP())
+		})
+
+		fmt.Fprintf(w, "%socspStapling:\n", ctx.Indent())
+		ctx.Wrap(func() {
+			s.TLS.OCSPStapling.dump(ctx, w)
+
+			if cs := s.CertSource(); cs != nil && s.TLS.OCSPStapling.Enable {
+				cs.dumpStaples(ctx, w)
+			}
+		})
+
+		if cs := s.CertSource(); cs != nil {
+			fmt.Fprintf(w, "%snotAfter: %s\n", ctx.Indent(), cs.NotAfter().Format(time.RFC3339))
+		}
+
 		if s.TLS.Enable && !s.tlsPreferServerCipherSuites() {
 			fmt.Fprintf(w, "%sWARNING: preferServerCipherSuites is false. %s\n",
 				ctx.Indent(), "Set to true for avoid potentinal security risk!")
@@ -179,6 +443,15 @@ func (s *ServerINET) Dump(ctx *dumpctx.Ctx, w io.Writer) {
 		s.ClientAuth.TLS.dump(ctx, w)
 	})
 
+	if s.ACMEHTTP01 {
+		fmt.Fprintf(w, "%sacmeHTTP01: %t\n", ctx.Indent(), s.ACMEHTTP01)
+	}
+
+	fmt.Fprintf(w, "%sproxyProtocol:\n", ctx.Indent())
+	ctx.Wrap(func() {
+		s.ProxyProtocol.dump(ctx, w)
+	})
+
 	s.ServerBase.Dump(ctx, w)
 }
 
@@ -1,10 +1,13 @@
 package servers
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/go-x-pkg/dumpctx"
 	"github.com/go-x-pkg/fnspath"
@@ -15,17 +18,80 @@ type ServerUNIX struct {
 
 	Address        string      `yaml:"addr"`
 	SocketFileMode os.FileMode `yaml:"socketFileMode"`
+	// SocketOwner and SocketGroup are user/group names or numeric ids.
+	SocketOwner string `yaml:"socketOwner"`
+	SocketGroup string `yaml:"socketGroup"`
+
+	inetOnly unixInetOnly
+}
+
+// unixInetOnly captures inet only options enabled on unix server
+// to reject such config on validate instead of silently ignore it.
+type unixInetOnly struct {
+	TLS struct {
+		Enable bool `json:"enable" yaml:"enable"`
+	} `json:"tls" yaml:"tls"`
+
+	ClientAuth struct {
+		TLS struct {
+			Enable bool `json:"enable" yaml:"enable"`
+		} `json:"tls" yaml:"tls"`
+	} `json:"clientAuth" yaml:"clientAuth"`
+}
+
+func (s *ServerUNIX) UnmarshalJSON(data []byte) error {
+	type plain ServerUNIX
+
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &s.inetOnly)
+}
+
+func (s *ServerUNIX) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ServerUNIX
+
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	return unmarshal(&s.inetOnly)
 }
 
 func (s *ServerUNIX) Base() *ServerBase { return &s.ServerBase }
 
 func (s *ServerUNIX) Addr() string { return s.Address }
 
+func (s *ServerUNIX) interpolate(interpolateFn func(string) string) {
+	s.ServerBase.interpolate(interpolateFn)
+
+	s.Address = interpolateFn(s.Address)
+	s.SocketOwner = interpolateFn(s.SocketOwner)
+	s.SocketGroup = interpolateFn(s.SocketGroup)
+}
+
+// IsAbstract reports whether address is in Linux abstract namespace (@name).
+// Abstract sockets have no file, so no mode, owner or parent dir.
+func (s *ServerUNIX) IsAbstract() bool { return strings.HasPrefix(s.Address, "@") }
+
 func (s *ServerUNIX) validate() error {
 	if err := s.ServerBase.validate(); err != nil {
 		return err
 	}
 
+	if s.inetOnly.TLS.Enable {
+		return fmt.Errorf("(:addr %q): %w", s.Addr(), ErrUnixTLSNotSupported)
+	}
+
+	if s.inetOnly.ClientAuth.TLS.Enable {
+		return fmt.Errorf("(:addr %q): %w", s.Addr(), ErrUnixClientAuthNotSupported)
+	}
+
+	if s.IsAbstract() {
+		return s.validateAbstract()
+	}
+
 	if v := s.Addr(); v != "" {
 		dir := filepath.Dir(v)
 		if exists, err := fnspath.IsExists(dir); err != nil {
@@ -37,6 +103,26 @@ func (s *ServerUNIX) validate() error {
 		return ErrUnixSocketPathNotProvided
 	}
 
+	if _, _, err := s.socketOwnership(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *ServerUNIX) validateAbstract() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("(:addr %q :os %s): %w", s.Addr(), runtime.GOOS, ErrUnixAbstractNotSupported)
+	}
+
+	if len(s.Addr()) == 1 {
+		return ErrUnixSocketPathNotProvided
+	}
+
+	if s.SocketOwner != "" || s.SocketGroup != "" {
+		return fmt.Errorf("(:addr %q): %w", s.Addr(), ErrUnixAbstractOwnership)
+	}
+
 	return nil
 }
 
@@ -53,8 +139,25 @@ func (s *ServerUNIX) defaultize() error {
 }
 
 func (s *ServerUNIX) Dump(ctx *dumpctx.Ctx, w io.Writer) {
+	if s.IsAbstract() {
+		fmt.Fprintf(w, "%saddr: %s # abstract\n", ctx.Indent(), s.Addr())
+		fmt.Fprintf(w, "%ssocketFileMode: ~\n", ctx.Indent())
+
+		s.ServerBase.Dump(ctx, w)
+
+		return
+	}
+
 	fmt.Fprintf(w, "%saddr: %s\n", ctx.Indent(), s.Addr())
 	fmt.Fprintf(w, "%ssocketFileMode: %03o | %s\n", ctx.Indent(), s.SocketFileMode, s.SocketFileMode)
 
+	if s.SocketOwner != "" {
+		fmt.Fprintf(w, "%ssocketOwner: %s\n", ctx.Indent(), s.SocketOwner)
+	}
+
+	if s.SocketGroup != "" {
+		fmt.Fprintf(w, "%ssocketGroup: %s\n", ctx.Indent(), s.SocketGroup)
+	}
+
 	s.ServerBase.Dump(ctx, w)
 }
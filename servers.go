@@ -51,11 +51,16 @@ func takeUnix(s Server) bool { return s.Kind().Has(KindUNIX) }
 func takeInet(s Server) bool { return s.Kind().Has(KindINET) }
 func takeHTTP(s Server) bool { return s.Kind().Has(KindHTTP) }
 func takeGRPC(s Server) bool { return s.Kind().Has(KindGRPC) }
+func takeMux(s Server) bool  { return takeHTTP(s) && takeGRPC(s) }
 
 func (it iterator) FilterUnix() iterator { return it.Filter(takeUnix) }
 func (it iterator) FilterInet() iterator { return it.Filter(takeInet) }
 func (it iterator) FilterHTTP() iterator { return it.Filter(takeHTTP) }
 func (it iterator) FilterGRPC() iterator { return it.Filter(takeGRPC) }
+func (it iterator) FilterMux() iterator  { return it.Filter(takeMux) }
+func (it iterator) FilterNoMux() iterator {
+	return it.Filter(func(s Server) bool { return !takeMux(s) })
+}
 func (it iterator) FilterListener() iterator {
 	return it.Filter(func(s Server) bool {
 		_, ok := s.(*ServerListener)
@@ -102,7 +107,12 @@ func (it iterator) Defaultize(inetHost string, inetPort int, unixAddr string) (e
 	return err
 }
 
+// Interpolate applies interpolateFn (e.g. ExpandEnv) to every string field.
 func (it iterator) Interpolate(interpolateFn func(string) string) {
+	if interpolateFn == nil {
+		return
+	}
+
 	it(func(s Server) bool {
 		if interpolator, ok := s.(serverInterpolator); ok {
 			interpolator.interpolate(interpolateFn)
@@ -113,7 +123,25 @@ func (it iterator) Interpolate(interpolateFn func(string) string) {
 }
 
 func (it iterator) Validate() (err error) {
+	names := map[string]bool{}
+
 	it(func(s Server) bool {
+		if name := s.Base().Name; name != "" {
+			if names[envKey(name)] {
+				err = fmt.Errorf("(:name %q): %w", name, ErrServerNameDuplicate)
+				return false
+			}
+
+			names[envKey(name)] = true
+		}
+
+		if interpolator, ok := s.(serverInterpolator); ok {
+			if e := validateInterpolated(interpolator); e != nil {
+				err = e
+				return false
+			}
+		}
+
 		if validator, ok := s.(serverValidator); ok {
 			if e := validator.validate(); e != nil {
 				err = e
@@ -217,26 +245,62 @@ func (ss *Servers) SetPortToFirstINET(port int) {
 		setPort(port)
 }
 
+// Listen opens listener per server.
+// Each error is *ListenerError of listen or chmod phase.
 func (ss *Servers) Listen(fnArgs ...Arg) (Servers, []error) {
 	return ss.IntoIter().Listen(fnArgs...)
 }
 
+// ServeHTTP serves http-only listeners.
+// Listeners of kind [http, grpc] are served by ServeMux.
 func (ss *Servers) ServeHTTP(fnNewServer func(Server) http.Handler, fnArgs ...Arg) error {
 	return ss.
 		IntoIter().
 		FilterHTTP().
+		FilterNoMux().
 		FilterListener().
 		ServeHTTP(fnNewServer, fnArgs...)
 }
 
+// ServeGRPC serves gRPC-only listeners.
+// Listeners of kind [http, grpc] are served by ServeMux.
 func (ss *Servers) ServeGRPC(
 	fnNewServer func(s Server, opts ...grpc.ServerOption) *grpc.Server, fnArgs ...Arg,
 ) error {
 	return ss.
 		IntoIter().
 		FilterGRPC().
+		FilterNoMux().
 		FilterListener().
 		ServeGRPC(fnNewServer, fnArgs...)
 }
 
+// ServeMux serves listeners of kind [http, grpc],
+// both protocols on the same listener.
+func (ss *Servers) ServeMux(
+	fnNewHandler func(Server) http.Handler,
+	fnNewServer func(s Server, opts ...grpc.ServerOption) *grpc.Server,
+	fnArgs ...Arg,
+) error {
+	return ss.
+		IntoIter().
+		FilterMux().
+		FilterListener().
+		ServeMux(fnNewHandler, fnNewServer, fnArgs...)
+}
+
+// Serve serves http, gRPC and HTTP+gRPC listeners under one lifecycle.
+// First failed listener shuts down the rest,
+// returned error names every failed listener.
+func (ss *Servers) Serve(
+	fnNewHandler func(Server) http.Handler,
+	fnNewServer func(s Server, opts ...grpc.ServerOption) *grpc.Server,
+	fnArgs ...Arg,
+) error {
+	return ss.
+		IntoIter().
+		FilterListener().
+		Serve(fnNewHandler, fnNewServer, fnArgs...)
+}
+
 func (ss *Servers) Close() []error { return ss.IntoIter().Close() }
@@ -49,7 +49,7 @@ func TestServers(t *testing.T) {
 		{`- kind: inet
   host: 0.0.0.0
   port: 443
-  tls:w
+  tls:
     enable: true
     certFile: "/etc/acme/tls.cert"
     keyFile: "/etc/acme/tls.key"
@@ -0,0 +1,360 @@
+package servers
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/fnspath"
+)
+
+// TLSCertificateConfig is one of certificates served by TLS listener,
+// selected by client SNI.
+type TLSCertificateConfig struct {
+	CertFile string `json:"certFile" yaml:"certFile" bson:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile" bson:"keyFile"`
+	// ServerNames certificate is selected for, "*.example.com" matches
+	// a single label. If empty DNS names of certificate are matched.
+	ServerNames []string `json:"serverNames" yaml:"serverNames" bson:"serverNames"`
+	// Default certificates are served when no other matches or client
+	// sent no SNI. First certificate is default if none is set.
+	// Set on both RSA and ECDSA ones to serve one client supports.
+	Default bool `json:"default" yaml:"default" bson:"default"`
+	// OCSPStapleFile is DER OCSP response of certificate
+	// stapled with tls.ocspStapling enabled.
+	OCSPStapleFile string `json:"ocspStapleFile" yaml:"ocspStapleFile" bson:"ocspStapleFile"`
+}
+
+func (c *TLSCertificateConfig) validate() error {
+	if err := validateCertKeyFiles(c.CertFile, c.KeyFile); err != nil {
+		return err
+	}
+
+	for _, v := range c.ServerNames {
+		if !isValidServerName(v) {
+			return fmt.Errorf("(:cert %q :serverName %q): %w", c.CertFile, v, ErrTLSServerNameInvalid)
+		}
+	}
+
+	return validateOCSPStapleFile(c.OCSPStapleFile)
+}
+
+func (c *TLSCertificateConfig) interpolate(interpolateFn func(string) string) {
+	c.CertFile = interpolateFn(c.CertFile)
+	c.KeyFile = interpolateFn(c.KeyFile)
+	interpolateStrings(interpolateFn, c.ServerNames)
+	c.OCSPStapleFile = interpolateFn(c.OCSPStapleFile)
+}
+
+func (c *TLSCertificateConfig) dump(ctx *dumpctx.Ctx, w io.Writer) {
+	ctx.EmitPrefix(w)
+
+	fmt.Fprintf(w, "certFile: %s\n", c.CertFile)
+
+	ctx.Enter()
+	defer ctx.Leave()
+
+	fmt.Fprintf(w, "%skeyFile: %s\n", ctx.Indent(), c.KeyFile)
+	fmt.Fprintf(w, "%sserverNames: [%s]\n", ctx.Indent(), strings.Join(c.ServerNames, ", "))
+	fmt.Fprintf(w, "%sdefault: %t\n", ctx.Indent(), c.Default)
+
+	if c.OCSPStapleFile != "" {
+		fmt.Fprintf(w, "%socspStapleFile: %s\n", ctx.Indent(), c.OCSPStapleFile)
+	}
+
+	dumpCertFile(ctx, w, c.CertFile)
+}
+
+// validateKeyPair parses pair and checks certificate is valid now.
+func (c *TLSCertificateConfig) validateKeyPair() error {
+	leaf, err := parseCertFile(c.CertFile)
+	if err != nil {
+		return err
+	}
+
+	key, err := parsePrivateKeyFile(c.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	if pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(key.Public()) {
+		return fmt.Errorf("(:cert %q :key %q): %w", c.CertFile, c.KeyFile, ErrTLSCertKeyMismatch)
+	}
+
+	if _, err := loadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("(:cert %q :notBefore %s): %w",
+			c.CertFile, leaf.NotBefore.Format(time.RFC3339), ErrTLSCertNotYetValid)
+	}
+
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("(:cert %q :notAfter %s): %w",
+			c.CertFile, leaf.NotAfter.Format(time.RFC3339), ErrTLSCertExpired)
+	}
+
+	return nil
+}
+
+// dumpCertFile prints subject, SANs, issuer and expiry of certificate.
+func dumpCertFile(ctx *dumpctx.Ctx, w io.Writer, path string) {
+	cert, err := parseCertFile(path)
+	if err != nil {
+		fmt.Fprintf(w, "%serror: %s\n", ctx.Indent(), err)
+		return
+	}
+
+	fmt.Fprintf(w, "%ssubject: %s\n", ctx.Indent(), cert.Subject)
+	fmt.Fprintf(w, "%ssans: [%s]\n", ctx.Indent(), strings.Join(certSANs(cert), ", "))
+	fmt.Fprintf(w, "%sissuer: %s\n", ctx.Indent(), cert.Issuer)
+	fmt.Fprintf(w, "%snotAfter: %s\n", ctx.Indent(), cert.NotAfter.Format(time.RFC3339))
+}
+
+// certSANs returns DNS names, IPs, emails and URIs of certificate.
+func certSANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	sans = append(sans, cert.EmailAddresses...)
+
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+
+	return sans
+}
+
+func validateCertKeyFiles(certFile, keyFile string) error {
+	if v := certFile; v != "" {
+		if exists, err := fnspath.IsExists(v); err != nil {
+			return fmt.Errorf("tls cert-file existence check failed: %w", err)
+		} else if !exists {
+			return fmt.Errorf("error (:path %q): %w", v, ErrTLSCertFileNotExists)
+		}
+	} else {
+		return ErrTLSCertFilePathNotProvided
+	}
+
+	if v := keyFile; v != "" {
+		if exists, err := fnspath.IsExists(v); err != nil {
+			return fmt.Errorf("tls key-file existence check failed: %w", err)
+		} else if !exists {
+			return fmt.Errorf("error (:path %q): %w", v, ErrTLSKeyFileNotExists)
+		}
+	} else {
+		return ErrTLSKeyFilePathNotProvided
+	}
+
+	return nil
+}
+
+// isValidServerName accepts host names and "*." wildcards of them.
+func isValidServerName(v string) bool {
+	v = strings.TrimPrefix(v, "*.")
+
+	return v != "" && !strings.ContainsAny(v, "*:/ ")
+}
+
+// matchServerName reports whether SNI name (lower-cased) matches pattern.
+func matchServerName(pattern, name string) bool {
+	pattern = strings.ToLower(pattern)
+
+	if !strings.HasPrefix(pattern, "*.") {
+		return pattern == name
+	}
+
+	i := strings.IndexByte(name, '.')
+
+	return i > 0 && name[i:] == pattern[1:]
+}
+
+// parseCertFile parses leaf (first) certificate of PEM file.
+func parseCertFile(path string) (*x509.Certificate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var block *pem.Block
+
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return nil, fmt.Errorf("(:cert %q): %w", path, ErrTLSCertNoPEM)
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parse x509 certificate (:cert %q): %w", path, err)
+		}
+
+		return cert, nil
+	}
+}
+
+// parsePrivateKeyFile parses first PKCS #8, PKCS #1 or EC private key of PEM file.
+func parsePrivateKeyFile(path string) (crypto.Signer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var block *pem.Block
+
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return nil, fmt.Errorf("(:key %q): %w", path, ErrTLSKeyNoPEM)
+		}
+
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+
+		if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if signer, ok := key.(crypto.Signer); ok {
+				return signer, nil
+			}
+
+			return nil, fmt.Errorf("(:key %q :type %T): %w", path, key, ErrTLSKeyUnsupported)
+		}
+
+		if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parse private key (:key %q): %w", path, err)
+		}
+
+		return key, nil
+	}
+}
+
+// tlsCertificates returns certFile/keyFile pair (if set)
+// followed by configured certificates.
+func (s *ServerINET) tlsCertificates() []TLSCertificateConfig {
+	if s.TLS.CertFile == "" && s.TLS.KeyFile == "" {
+		return s.TLS.Certificates
+	}
+
+	return append([]TLSCertificateConfig{{
+		CertFile:       s.TLS.CertFile,
+		KeyFile:        s.TLS.KeyFile,
+		OCSPStapleFile: s.TLS.OCSPStapling.File,
+	}}, s.TLS.Certificates...)
+}
+
+func (s *ServerINET) validateTLSCertificates() error {
+	if len(s.TLS.Certificates) == 0 || s.TLS.CertFile != "" || s.TLS.KeyFile != "" {
+		if err := validateCertKeyFiles(s.TLS.CertFile, s.TLS.KeyFile); err != nil {
+			return err
+		}
+	}
+
+	for i := range s.TLS.Certificates {
+		if err := s.TLS.Certificates[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	if v := s.tlsExpiryWarning(); v < 0 {
+		return fmt.Errorf("(:expiryWarning %s): %w", v, ErrTLSExpiryWarningNegative)
+	}
+
+	for _, c := range s.tlsCertificates() {
+		if err := c.validateKeyPair(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectCertificate picks certificate for client hello:
+// exact serverNames match, then wildcard one, then one whose DNS names
+// match SNI, then default ones. Within each group first certificate
+// client supports (key type, signature schemes) wins.
+//
+// certs are parallel to configs.
+func selectCertificate(hello *tls.ClientHelloInfo,
+	configs []TLSCertificateConfig, certs []*tls.Certificate,
+) *tls.Certificate {
+	// names are matched by groups, check algorithms only
+	algo := *hello
+	algo.ServerName = ""
+
+	pick := func(match func(i int) bool) *tls.Certificate {
+		var first *tls.Certificate
+
+		for i, cert := range certs {
+			if !match(i) {
+				continue
+			}
+
+			if algo.SupportsCertificate(cert) == nil {
+				return cert
+			}
+
+			if first == nil {
+				first = cert
+			}
+		}
+
+		return first
+	}
+
+	if name := strings.TrimSuffix(strings.ToLower(hello.ServerName), "."); name != "" {
+		matchNames := func(wildcard bool) func(int) bool {
+			return func(i int) bool {
+				for _, v := range configs[i].ServerNames {
+					if strings.HasPrefix(v, "*.") == wildcard && matchServerName(v, name) {
+						return true
+					}
+				}
+
+				return false
+			}
+		}
+
+		for _, match := range []func(int) bool{
+			matchNames(false),
+			matchNames(true),
+			func(i int) bool {
+				return len(configs[i].ServerNames) == 0 && certs[i].Leaf != nil &&
+					certs[i].Leaf.VerifyHostname(name) == nil
+			},
+		} {
+			if cert := pick(match); cert != nil {
+				return cert
+			}
+		}
+	}
+
+	hasDefault := false
+
+	for i := range configs {
+		hasDefault = hasDefault || configs[i].Default
+	}
+
+	return pick(func(i int) bool { return configs[i].Default || (!hasDefault && i == 0) })
+}
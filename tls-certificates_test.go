@@ -0,0 +1,298 @@
+package servers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/servers"
+)
+
+func TestTLSCertificatesValidate(t *testing.T) {
+	dir := t.TempDir()
+	cert := newTestCert(t, dir, "server", nil, testCertOpts{cn: "server"})
+	missing := filepath.Join(dir, "missing.crt")
+
+	for i, tt := range []struct {
+		tls string
+		err error
+	}{
+		{fmt.Sprintf("certificates:\n    - certFile: %q\n      keyFile: %q", cert.certFile, cert.keyFile), nil},
+		{fmt.Sprintf("certificates:\n    - certFile: %q\n      keyFile: %q", missing, cert.keyFile), servers.ErrTLSCertFileNotExists},
+		{fmt.Sprintf("certificates:\n    - certFile: %q", cert.certFile), servers.ErrTLSKeyFilePathNotProvided},
+		{fmt.Sprintf("certFile: %q\n    certificates:\n    - certFile: %q\n      keyFile: %q", cert.certFile, cert.certFile, cert.keyFile), servers.ErrTLSKeyFilePathNotProvided},
+		{fmt.Sprintf("certificates:\n    - certFile: %q\n      keyFile: %q\n      serverNames: [\"*\"]", cert.certFile, cert.keyFile), servers.ErrTLSServerNameInvalid},
+		{fmt.Sprintf("certificates:\n    - certFile: %q\n      keyFile: %q\n      serverNames: [\"a.*.example.com\"]", cert.certFile, cert.keyFile), servers.ErrTLSServerNameInvalid},
+		{fmt.Sprintf("acme: {enable: true, domains: [example.com], cacheDir: %q}\n    certificates:\n    - certFile: %q\n      keyFile: %q", dir, cert.certFile, cert.keyFile), servers.ErrACMEWithCertFile},
+	} {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(fmt.Sprintf("- kind: [inet, http]\n  tls:\n    enable: true\n    %s", tt.tls), &ss); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+
+		ss.Defaultize("127.0.0.1", 0, "")
+
+		if err := ss.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("%d: expected %v, got %v", i, tt.err, err)
+		}
+	}
+}
+
+func TestTLSCertificatesSNI(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+
+	notAfter := time.Now().Add(72 * time.Hour).Truncate(time.Second).UTC()
+
+	fallback := newTestCert(t, dir, "fallback", ca, testCertOpts{cn: "fallback", dns: []string{"fallback.test"}})
+	exact := newTestCert(t, dir, "exact", ca, testCertOpts{cn: "exact", dns: []string{"a.example.com"}, notAfter: notAfter})
+	wildcard := newTestCert(t, dir, "wildcard", ca, testCertOpts{cn: "wildcard", dns: []string{"*.example.com"}})
+	bySAN := newTestCert(t, dir, "by-san", ca, testCertOpts{cn: "by-san", dns: []string{"san.test"}})
+	dualRSA := newTestCert(t, dir, "dual-rsa", ca, testCertOpts{cn: "dual-rsa", dns: []string{"dual.test"}, rsa: true})
+	dualECDSA := newTestCert(t, dir, "dual-ecdsa", ca, testCertOpts{cn: "dual-ecdsa", dns: []string{"dual.test"}})
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    certificates:
+    - certFile: %q
+      keyFile: %q
+      serverNames: [A.example.com]
+    - certFile: %q
+      keyFile: %q
+      serverNames: ["*.example.com"]
+    - certFile: %q
+      keyFile: %q
+    - certFile: %q
+      keyFile: %q
+      serverNames: [dual.test]
+    - certFile: %q
+      keyFile: %q
+      serverNames: [dual.test]
+    minVersion: tls-1.2`,
+		fallback.certFile, fallback.keyFile,
+		exact.certFile, exact.keyFile,
+		wildcard.certFile, wildcard.keyFile,
+		bySAN.certFile, bySAN.keyFile,
+		dualECDSA.certFile, dualECDSA.keyFile,
+		dualRSA.certFile, dualRSA.keyFile))
+
+	w := bytes.Buffer{}
+	dctx := dumpctx.Ctx{}
+	dctx.Init()
+
+	ss.Dump(&dctx, &w)
+
+	for _, v := range []string{
+		"certificates (x5):", "serverNames: [A.example.com]", "subject: CN=exact",
+		"notAfter: " + notAfter.Format(time.RFC3339), "subject: CN=dual-rsa",
+	} {
+		if !strings.Contains(w.String(), v) {
+			t.Errorf("dump has no %q:\n%s", v, w.String())
+		}
+	}
+
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() { done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx)) }()
+
+	defer func() { cancel(); <-done }()
+
+	for _, tt := range []struct {
+		serverName string
+		rsaOnly    bool
+		expected   string
+	}{
+		{"", false, "fallback"},
+		{"unknown.test", false, "fallback"},
+		{"a.example.com", false, "exact"},
+		{"b.example.com", false, "wildcard"},
+		{"a.b.example.com", false, "fallback"},
+		{"san.test", false, "by-san"},
+		{"dual.test", false, "dual-ecdsa"},
+		{"dual.test", true, "dual-rsa"},
+	} {
+		// fallback is served for names it's not valid for
+		cfg := &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true} //nolint: gosec
+
+		if tt.rsaOnly {
+			cfg.MaxVersion = tls.VersionTLS12
+			cfg.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+		}
+
+		conn, err := tls.Dial("tcp", addr, cfg)
+		if err != nil {
+			t.Fatalf("%q: dial: %s", tt.serverName, err)
+		}
+
+		cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		conn.Close()
+
+		if cn != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.serverName, tt.expected, cn)
+		}
+	}
+
+	inet := ss[0].Server.(*servers.ServerINET)
+
+	if n := len(inet.CertSource().Certificates()); n != 6 {
+		t.Errorf("expected 6 certificates loaded, got %d", n)
+	}
+
+	if cn := inet.CertSource().Certificate().Leaf.Subject.CommonName; cn != "fallback" {
+		t.Errorf("unexpected default certificate %q", cn)
+	}
+}
+
+func TestTLSCertificatesValidity(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	valid := newTestCert(t, dir, "valid", ca, testCertOpts{
+		cn: "valid", dns: []string{"valid.test"}, notAfter: time.Now().Add(7 * 24 * time.Hour),
+	})
+	other := newTestCert(t, dir, "other", ca, testCertOpts{cn: "other"})
+	expired := newTestCert(t, dir, "expired", ca, testCertOpts{
+		cn: "expired", notBefore: time.Now().Add(-48 * time.Hour), notAfter: time.Now().Add(-time.Hour),
+	})
+	notYetValid := newTestCert(t, dir, "not-yet-valid", ca, testCertOpts{
+		cn: "not-yet-valid", notBefore: time.Now().Add(time.Hour), notAfter: time.Now().Add(48 * time.Hour),
+	})
+
+	for i, tt := range []struct {
+		certFile, keyFile string
+		err               error
+	}{
+		{valid.certFile, valid.keyFile, nil},
+		{valid.certFile, other.keyFile, servers.ErrTLSCertKeyMismatch},
+		{expired.certFile, expired.keyFile, servers.ErrTLSCertExpired},
+		{notYetValid.certFile, notYetValid.keyFile, servers.ErrTLSCertNotYetValid},
+		{valid.keyFile, valid.keyFile, servers.ErrTLSCertNoPEM},
+	} {
+		for _, raw := range []string{
+			fmt.Sprintf("certFile: %q\n    keyFile: %q", tt.certFile, tt.keyFile),
+			fmt.Sprintf("certificates:\n    - certFile: %q\n      keyFile: %q", tt.certFile, tt.keyFile),
+		} {
+			var ss servers.Servers
+
+			if err := yamlUnmarshal(fmt.Sprintf("- kind: [inet, http]\n  tls:\n    enable: true\n    %s", raw), &ss); err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+
+			ss.Defaultize("127.0.0.1", 0, "")
+
+			if err := ss.Validate(); !errors.Is(err, tt.err) {
+				t.Errorf("%d: expected %v, got %v", i, tt.err, err)
+			}
+		}
+	}
+
+	expiring := newTestCert(t, dir, "expiring", ca, testCertOpts{cn: "expiring", notAfter: time.Now().Add(2 * time.Hour)})
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    certificates:
+    - certFile: %q
+      keyFile: %q
+    expiryWarning: 24h`, valid.certFile, valid.keyFile, expiring.certFile, expiring.keyFile))
+
+	w := bytes.Buffer{}
+	dctx := dumpctx.Ctx{}
+	dctx.Init()
+
+	ss.Dump(&dctx, &w)
+
+	for _, v := range []string{
+		"subject: CN=valid", "sans: [valid.test]", "issuer: CN=ca",
+		"notAfter: " + valid.cert.NotAfter.Format(time.RFC3339), "expiryWarning: 24h0m0s",
+	} {
+		if !strings.Contains(w.String(), v) {
+			t.Errorf("dump has no %q:\n%s", v, w.String())
+		}
+	}
+
+	lg := testLog{}
+	listeners := listenTestServers(t, ss)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx), servers.FnLog(lg.fn))
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !lg.contains("CN=expiring") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+
+	if !lg.contains(fmt.Sprintf("(:cert %q :subject \"CN=expiring\") expires in", expiring.certFile)) {
+		t.Errorf("no expiry warning logged: %q", lg.msgs)
+	}
+
+	if lg.contains("CN=valid") {
+		t.Errorf("unexpected expiry warning: %q", lg.msgs)
+	}
+
+	// 0 disables warning
+	ss = newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    expiryWarning: 0s`, expiring.certFile, expiring.keyFile))
+
+	lg = testLog{}
+	listeners = listenTestServers(t, ss)
+
+	ctx, cancel = context.WithCancel(context.Background())
+
+	go func() {
+		done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx), servers.FnLog(lg.fn))
+	}()
+
+	// warnings are logged on cert source build
+	inet := ss[0].Server.(*servers.ServerINET)
+
+	deadline = time.Now().Add(5 * time.Second)
+	for inet.CertSource() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+
+	if lg.contains("expires in") {
+		t.Errorf("expiry warning is not disabled: %q", lg.msgs)
+	}
+}
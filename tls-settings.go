@@ -0,0 +1,250 @@
+package servers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// TLS presets follow Mozilla server side TLS guidelines
+// (https://wiki.mozilla.org/Security/Server_Side_TLS).
+// Cipher suites crypto/tls doesn't implement (DHE, CBC-SHA384, ...) are omitted,
+// TLS 1.3 suites are not configurable.
+const (
+	TLSPresetModern       = "modern"
+	TLSPresetIntermediate = "intermediate"
+	TLSPresetOld          = "old"
+)
+
+type tlsPreset struct {
+	minVersion       versionTLS
+	cipherSuites     []string
+	curvePreferences []string
+}
+
+var (
+	tlsPresetCurves = []string{"X25519", "P-256", "P-384"}
+
+	tlsPresetIntermediateCipherSuites = []string{
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+		"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	}
+
+	tlsPresets = map[string]tlsPreset{
+		TLSPresetModern: {
+			minVersion:       versionTLS13,
+			curvePreferences: tlsPresetCurves,
+		},
+		TLSPresetIntermediate: {
+			minVersion:       versionTLS12,
+			cipherSuites:     tlsPresetIntermediateCipherSuites,
+			curvePreferences: tlsPresetCurves,
+		},
+		TLSPresetOld: {
+			minVersion: versionTLS10,
+			cipherSuites: append(append([]string{}, tlsPresetIntermediateCipherSuites...),
+				"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+				"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+				"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+				"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+				"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+				"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+				"TLS_RSA_WITH_AES_128_GCM_SHA256",
+				"TLS_RSA_WITH_AES_256_GCM_SHA384",
+				"TLS_RSA_WITH_AES_128_CBC_SHA256",
+				"TLS_RSA_WITH_AES_128_CBC_SHA",
+				"TLS_RSA_WITH_AES_256_CBC_SHA",
+				"TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+			),
+			curvePreferences: tlsPresetCurves,
+		},
+	}
+)
+
+func tlsCurveID(name string) (tls.CurveID, bool) {
+	switch strings.ToUpper(name) {
+	case "X25519":
+		return tls.X25519, true
+	case "P-256", "P256", "CURVEP256", "SECP256R1", "PRIME256V1":
+		return tls.CurveP256, true
+	case "P-384", "P384", "CURVEP384", "SECP384R1":
+		return tls.CurveP384, true
+	case "P-521", "P521", "CURVEP521", "SECP521R1":
+		return tls.CurveP521, true
+	default:
+		return 0, false
+	}
+}
+
+func tlsCipherSuiteID(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, cs := range suites {
+			if cs.Name == name {
+				return cs.ID, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func tlsCipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint16, 0, len(names))
+
+	for _, name := range names {
+		id, ok := tlsCipherSuiteID(name)
+		if !ok {
+			return nil, fmt.Errorf("(:cipherSuite %q): %w", name, ErrTLSCipherSuiteUnknown)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func tlsCurveIDs(names []string) ([]tls.CurveID, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]tls.CurveID, 0, len(names))
+
+	for _, name := range names {
+		id, ok := tlsCurveID(name)
+		if !ok {
+			return nil, fmt.Errorf("(:curve %q): %w", name, ErrTLSCurveUnknown)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func validateTLSNextProtos(protos []string) error {
+	for _, v := range protos {
+		if v == "" || len(v) > 255 {
+			return fmt.Errorf("(:nextProto %q): %w", v, ErrTLSNextProtoInvalid)
+		}
+	}
+
+	return nil
+}
+
+// tlsHasHTTP2CipherSuite reports whether names (crypto/tls defaults if empty)
+// have suite HTTP/2 requires over TLS 1.2 (RFC 7540, 9.2.2).
+func tlsHasHTTP2CipherSuite(names []string) bool {
+	if len(names) == 0 {
+		return true
+	}
+
+	return containsString(names, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256") ||
+		containsString(names, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+}
+
+func containsString(vs []string, v string) bool {
+	for _, x := range vs {
+		if x == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tlsServerNextProtos are ALPN protocols served by server itself.
+func (s *ServerINET) tlsServerNextProtos() []string {
+	switch {
+	case s.Kind().Has(KindHTTP):
+		return []string{"h2", "http/1.1"}
+	case s.Kind().Has(KindGRPC):
+		return []string{"h2"}
+	}
+
+	return nil
+}
+
+// tlsCipherSuites returns configured cipher suites or ones of preset.
+func (s *ServerINET) tlsCipherSuites() []string {
+	if len(s.TLS.CipherSuites) != 0 {
+		return s.TLS.CipherSuites
+	}
+
+	return tlsPresets[s.TLS.Preset].cipherSuites
+}
+
+// tlsCurvePreferences returns configured curves or ones of preset.
+func (s *ServerINET) tlsCurvePreferences() []string {
+	if len(s.TLS.CurvePreferences) != 0 {
+		return s.TLS.CurvePreferences
+	}
+
+	return tlsPresets[s.TLS.Preset].curvePreferences
+}
+
+func (s *ServerINET) validateTLSSettings() error {
+	if v := s.TLS.Preset; v != "" {
+		if _, ok := tlsPresets[v]; !ok {
+			return fmt.Errorf("(:preset %q): %w", v, ErrTLSPresetUnknown)
+		}
+	}
+
+	if _, err := tlsCipherSuiteIDs(s.TLS.CipherSuites); err != nil {
+		return err
+	}
+
+	if _, err := tlsCurveIDs(s.TLS.CurvePreferences); err != nil {
+		return err
+	}
+
+	if err := validateTLSNextProtos(s.TLS.NextProtos); err != nil {
+		return err
+	}
+
+	if s.Kind().Has(KindHTTP) && s.TLS.MinVersion.orDefault() <= versionTLS12 &&
+		!tlsHasHTTP2CipherSuite(s.tlsCipherSuites()) {
+		return fmt.Errorf("(:cipherSuites [%s]): %w", strings.Join(s.tlsCipherSuites(), ", "), ErrTLSCipherSuiteNoHTTP2)
+	}
+
+	if s.TLS.MinVersion.orDefault() > s.TLS.MaxVersion.orDefault() {
+		return fmt.Errorf("(:minVersion %s :maxVersion %s): %w",
+			s.TLS.MinVersion.orDefault(), s.TLS.MaxVersion.orDefault(), ErrTLSMinVersionExceedsMax)
+	}
+
+	return nil
+}
+
+// applyTLSSettings sets cipher suites, curves, ALPN and session tickets.
+func (s *ServerINET) applyTLSSettings(tlsConfig *tls.Config) error {
+	cipherSuites, err := tlsCipherSuiteIDs(s.tlsCipherSuites())
+	if err != nil {
+		return err
+	}
+
+	curves, err := tlsCurveIDs(s.tlsCurvePreferences())
+	if err != nil {
+		return err
+	}
+
+	tlsConfig.CipherSuites = cipherSuites
+	tlsConfig.CurvePreferences = curves
+	// own protocols go first, server preference wins on ALPN
+	for _, proto := range append(s.tlsServerNextProtos(), s.TLS.NextProtos...) {
+		if !containsString(tlsConfig.NextProtos, proto) {
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, proto)
+		}
+	}
+
+	tlsConfig.SessionTicketsDisabled = s.TLS.SessionTicketsDisabled
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package servers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-x-pkg/dumpctx"
+	"github.com/go-x-pkg/servers"
+)
+
+func TestTLSSettingsValidate(t *testing.T) {
+	for i, tt := range []struct {
+		kind string
+		tls  string
+		err  error
+	}{
+		{"http", "preset: intermediate", nil},
+		{"http", "preset: paranoid", servers.ErrTLSPresetUnknown},
+		{"http", "cipherSuites: [TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, ECDHE-RSA-AES128-GCM-SHA256]", servers.ErrTLSCipherSuiteUnknown},
+		{"http", "curvePreferences: [X25519, P-192]", servers.ErrTLSCurveUnknown},
+		{"http", `nextProtos: [""]`, servers.ErrTLSNextProtoInvalid},
+		{"http", "minVersion: tls-1.3\n    maxVersion: tls-1.2", servers.ErrTLSMinVersionExceedsMax},
+		// http2 over tls 1.2 requires ECDHE AES_128_GCM_SHA256
+		{"http", "minVersion: tls-1.2\n    cipherSuites: [TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384]", servers.ErrTLSCipherSuiteNoHTTP2},
+		{"http", "preset: old\n    cipherSuites: [TLS_RSA_WITH_AES_128_GCM_SHA256]", servers.ErrTLSCipherSuiteNoHTTP2},
+		{"http", "minVersion: tls-1.3\n    cipherSuites: [TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384]", nil},
+		{"grpc", "minVersion: tls-1.2\n    cipherSuites: [TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384]", nil},
+	} {
+		var ss servers.Servers
+
+		if err := yamlUnmarshal(fmt.Sprintf("- kind: [inet, %s]\n  tls:\n    %s", tt.kind, tt.tls), &ss); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+
+		ss.Defaultize("127.0.0.1", 0, "")
+
+		if err := ss.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("%d: expected %v, got %v", i, tt.err, err)
+		}
+	}
+}
+
+func TestTLSSettings(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, dir, "ca", nil, testCertOpts{cn: "ca", isCA: true})
+	cert := newTestCert(t, dir, "server", ca, testCertOpts{cn: "server", ips: []net.IP{net.IPv4(127, 0, 0, 1)}})
+
+	ss := newTestServers(t, fmt.Sprintf(`- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    preset: intermediate
+    maxVersion: tls-1.2
+    cipherSuites: [TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256]
+    nextProtos: [custom/1]
+    sessionTicketsDisabled: true
+- kind: [inet, http]
+  host: 127.0.0.1
+  tls:
+    enable: true
+    certFile: %q
+    keyFile: %q
+    preset: modern`, cert.certFile, cert.keyFile, cert.certFile, cert.keyFile))
+
+	w := bytes.Buffer{}
+	dctx := dumpctx.Ctx{}
+	dctx.Init()
+
+	ss.Dump(&dctx, &w)
+
+	for _, v := range []string{
+		"preset: intermediate", "minVersion: tls-1.2", "cipherSuites: [TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256]",
+		"curvePreferences: [X25519, P-256, P-384]", "nextProtos: [custom/1]", "sessionTicketsDisabled: true",
+		"preset: modern", "minVersion: tls-1.3",
+	} {
+		if !strings.Contains(w.String(), v) {
+			t.Errorf("dump has no %q:\n%s", v, w.String())
+		}
+	}
+
+	listeners := listenTestServers(t, ss)
+	intermediate := listenerAddr(t, listeners, ss[0].Server)
+	modern := listenerAddr(t, listeners, ss[1].Server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() { done <- listeners.ServeHTTP(serveOneBody("app"), servers.Context(ctx)) }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	conn, err := tls.Dial("tcp", intermediate, &tls.Config{
+		RootCAs: pool, MinVersion: tls.VersionTLS12, NextProtos: []string{"custom/1"},
+	})
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	state := conn.ConnectionState()
+	conn.Close()
+
+	if state.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suite %s", tls.CipherSuiteName(state.CipherSuite))
+	}
+
+	if state.NegotiatedProtocol != "custom/1" {
+		t.Errorf("unexpected protocol %q", state.NegotiatedProtocol)
+	}
+
+	// own protocols of server are preferred
+	conn, err = tls.Dial("tcp", intermediate, &tls.Config{
+		RootCAs: pool, MinVersion: tls.VersionTLS12, NextProtos: []string{"custom/1", "http/1.1", "h2"},
+	})
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != "h2" {
+		t.Errorf("expected h2 to be preferred, got %q", proto)
+	}
+
+	conn.Close()
+
+	if conn, err := tls.Dial("tcp", modern, &tls.Config{
+		RootCAs: pool, MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12,
+	}); err == nil {
+		conn.Close()
+		t.Errorf("modern preset accepted tls 1.2")
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+}
@@ -0,0 +1,279 @@
+package servers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	xlog "github.com/go-x-pkg/log"
+)
+
+// child reports readiness by writing to this fd.
+const envUpgradeReadyFD = "SERVERS_UPGRADE_READY_FD"
+
+type (
+	fileListener   interface{ File() (*os.File, error) }
+	unlinkOnCloser interface{ SetUnlinkOnClose(bool) }
+)
+
+// Upgrader hands listening sockets over to new binary
+// for zero-downtime restart.
+//
+// Upgrade starts new binary with listeners passed as LISTEN_FDS,
+// waits it to call UpgradeReady and then cancels Context,
+// so ServeHTTP/ServeGRPC/ServeMux given servers.Context(u.Context())
+// shut down gracefully. New binary must Listen with SocketActivation(true).
+type Upgrader struct {
+	listeners Servers
+	cfg       *args
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	upgraded bool
+}
+
+func NewUpgrader(listeners Servers, fnArgs ...Arg) *Upgrader {
+	cfg := newArgs(fnArgs...)
+
+	ctx := cfg.ctx
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+
+	u := &Upgrader{listeners: listeners, cfg: cfg}
+	u.ctx, u.cancel = context.WithCancel(ctx)
+
+	return u
+}
+
+// Context is canceled once new binary is ready.
+func (u *Upgrader) Context() context.Context { return u.ctx }
+
+// WatchSignal runs Upgrade on each of sigs (e.g. syscall.SIGUSR2)
+// until ctx or Context is done.
+func (u *Upgrader) WatchSignal(ctx context.Context, sigs ...os.Signal) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, sigs...)
+
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-u.ctx.Done():
+			return
+		case s := <-sig:
+			u.cfg.fnLog(xlog.Info, "upgrade on signal %s", s)
+
+			if err := u.Upgrade(); err != nil {
+				u.cfg.fnLog(xlog.Error, "upgrade failed, keep serving: %s", err)
+			}
+		}
+	}
+}
+
+// Upgrade starts new binary and waits for its readiness.
+// On failure child is killed and current process keeps serving.
+func (u *Upgrader) Upgrade() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.upgraded {
+		return ErrUpgradeDone
+	}
+
+	files, names, addrs, err := u.files()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("upgrade ready pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	cmd := u.cfg.fnUpgradeCommand()
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(envWithout(cmd.Env, envListenPID, envListenPPID, envListenFDs, envListenFDNames, envListenAddrs, envUpgradeReadyFD),
+		envListenPPID+"="+strconv.Itoa(os.Getpid()),
+		envListenFDs+"="+strconv.Itoa(len(files)),
+		envListenFDNames+"="+strings.Join(names, ":"),
+		envListenAddrs+"="+addrs,
+		envUpgradeReadyFD+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+
+	if err := cmd.Start(); err != nil {
+		readyW.Close()
+
+		return fmt.Errorf("upgrade start %q: %w", cmd.Path, err)
+	}
+
+	// only child holds write end now, EOF means child exited
+	readyW.Close()
+
+	u.cfg.fnLog(xlog.Info, "upgrade (:pid %d :fds %d) started, waiting for ready", cmd.Process.Pid, len(files))
+
+	if err := waitReady(readyR, u.cfg.upgradeReadyTimeout); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		return fmt.Errorf("upgrade (:pid %d): %w", cmd.Process.Pid, err)
+	}
+
+	// child owns sockets now, don't unlink them on drain
+	u.listeners.IntoIter().FilterListener()(func(s Server) bool {
+		if ul, ok := s.(*ServerListener).Listener.(unlinkOnCloser); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+
+		return true
+	})
+
+	go cmd.Wait()
+
+	u.upgraded = true
+	u.cfg.fnLog(xlog.Info, "upgrade (:pid %d) ready, draining", cmd.Process.Pid)
+	u.cancel()
+
+	return nil
+}
+
+func (u *Upgrader) files() (files []*os.File, names []string, addrs string, err error) {
+	var paths []string
+
+	u.listeners.IntoIter().FilterListener()(func(s Server) bool {
+		l := s.(*ServerListener)
+
+		fl, ok := l.Listener.(fileListener)
+		if !ok {
+			err = fmt.Errorf("listener (%s): %w", l.Addr(), ErrUpgradeNoFile)
+			return false
+		}
+
+		f, e := fl.File()
+		if e != nil {
+			err = fmt.Errorf("listener (%s) file: %w", l.Addr(), e)
+			return false
+		}
+
+		path := ""
+		if ul, ok := l.Listener.(*unixListener); ok {
+			path = ul.path
+		}
+
+		files = append(files, f)
+		names = append(names, l.Base().FDName)
+		paths = append(paths, path)
+
+		return true
+	})
+
+	if err == nil {
+		var raw []byte
+
+		raw, err = json.Marshal(paths)
+		addrs = string(raw)
+	}
+
+	if err != nil {
+		for _, f := range files {
+			f.Close()
+		}
+
+		return nil, nil, "", err
+	}
+
+	return files, names, addrs, nil
+}
+
+func waitReady(r *os.File, timeout time.Duration) error {
+	r.SetReadDeadline(time.Now().Add(timeout))
+
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		if err == io.EOF {
+			return ErrUpgradeChildExited
+		}
+
+		return fmt.Errorf("%s: %w", err, ErrUpgradeNotReady)
+	}
+
+	return nil
+}
+
+func envWithout(env []string, keys ...string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+
+	out := make([]string, 0, len(env))
+
+next:
+	for _, kv := range env {
+		for _, k := range keys {
+			if strings.HasPrefix(kv, k+"=") {
+				continue next
+			}
+		}
+
+		out = append(out, kv)
+	}
+
+	return out
+}
+
+// UpgradeReady notifies parent Upgrader that new process is ready
+// (listened and started serving). No-op if process wasn't started by Upgrader.
+func UpgradeReady() error {
+	raw := os.Getenv(envUpgradeReadyFD)
+	if raw == "" {
+		return nil
+	}
+
+	os.Unsetenv(envUpgradeReadyFD)
+
+	fd, err := strconv.Atoi(raw)
+	if err != nil {
+		return fmt.Errorf("%s=%q: %w", envUpgradeReadyFD, raw, err)
+	}
+
+	f := os.NewFile(uintptr(fd), "upgrade-ready")
+	defer f.Close()
+
+	if _, err := f.Write([]byte{1}); err != nil {
+		return fmt.Errorf("upgrade notify ready: %w", err)
+	}
+
+	return nil
+}
+
+func defaultFnUpgradeCommand() *exec.Cmd {
+	path, err := os.Executable()
+	if err != nil {
+		path = os.Args[0]
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd
+}
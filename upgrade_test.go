@@ -0,0 +1,143 @@
+package servers_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-x-pkg/servers"
+)
+
+const envTestUpgradeChild = "SERVERS_TEST_UPGRADE_CHILD"
+
+func serveOneBody(body string) func(servers.Server) http.Handler {
+	return func(servers.Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		})
+	}
+}
+
+// TestUpgradeChild is new binary started by TestUpgrade
+// with servers config passed in environment.
+func TestUpgradeChild(t *testing.T) {
+	config := os.Getenv(envTestUpgradeChild)
+	if config == "" {
+		t.Skip("subprocess only")
+	}
+
+	ss := newTestServers(t, config)
+	listeners := listenTestServers(t, ss, servers.SocketActivation(true))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+	if err := servers.UpgradeReady(); err != nil {
+		t.Fatalf("ready: %s", err)
+	}
+
+	err := listeners.ServeHTTP(func(servers.Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "new")
+			cancel()
+		})
+	}, servers.Context(ctx))
+	if err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "app.sock")
+
+	for _, tt := range []struct {
+		name string
+		// port is unknown for child, so inet is matched by name
+		config string
+	}{
+		{"inet by fd name", `- kind: [inet, http]
+  fdName: web`},
+		{"unix by path", fmt.Sprintf(`- kind: [unix, http]
+  addr: %q`, sock)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testUpgrade(t, tt.config)
+		})
+	}
+}
+
+func testUpgrade(t *testing.T, config string) {
+	ss := newTestServers(t, config)
+	listeners := listenTestServers(t, ss)
+	addr := listenerAddr(t, listeners, ss[0].Server)
+
+	u := servers.NewUpgrader(listeners,
+		servers.UpgradeReadyTimeout(10*time.Second),
+		servers.FnUpgradeCommand(func() *exec.Cmd {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestUpgradeChild$")
+			cmd.Env = append(os.Environ(), envTestUpgradeChild+"="+config)
+			cmd.Stdout = os.Stderr
+			cmd.Stderr = os.Stderr
+
+			return cmd
+		}),
+	)
+
+	done := make(chan error, 1)
+
+	go func() { done <- listeners.ServeHTTP(serveOneBody("old"), servers.Context(u.Context())) }()
+
+	transport := &http.Transport{DisableKeepAlives: true}
+	url := "http://" + addr + "/"
+
+	if ss[0].Server.Kind().Has(servers.KindUNIX) {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+		}
+		url = "http://unix/"
+	}
+
+	client := http.Client{Timeout: 10 * time.Second, Transport: transport}
+
+	get := func() string {
+		t.Helper()
+
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("GET: %s", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		return string(body)
+	}
+
+	if body := get(); body != "old" {
+		t.Fatalf("expected old process to serve, got %q", body)
+	}
+
+	if err := u.Upgrade(); err != nil {
+		t.Fatalf("upgrade: %s", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("old process serve: %s", err)
+	}
+
+	listeners.Close()
+
+	if body := get(); body != "new" {
+		t.Errorf("expected new process to serve, got %q", body)
+	}
+
+	if err := u.Upgrade(); err == nil {
+		t.Errorf("second upgrade must fail")
+	}
+}